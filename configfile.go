@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"go-socket-storm/loadtest"
+)
+
+// commandLineLists names the repeatable flags given on the command line, as
+// noted by noteCommandLineLists before a config file is applied.
+var commandLineLists []string
+
+// noteCommandLineLists records which repeatable flags the command line set.
+func noteCommandLineLists() {
+	flag.Visit(func(f *flag.Flag) {
+		if isListFlag(f) {
+			commandLineLists = append(commandLineLists, f.Name)
+		}
+	})
+}
+
+// parseOverConfigFile applies the config file at path on top of the
+// defaults and parses args, the command line, again over it, so flags given
+// there win. A repeatable flag given there replaces the file's list rather
+// than adding to it.
+func parseOverConfigFile(path string, args []string) error {
+	cfg = loadtest.DefaultConfig()
+	if err := applyConfigFile(path); err != nil {
+		return err
+	}
+	for _, name := range commandLineLists {
+		switch v := flag.Lookup(name).Value.(type) {
+		case *listFlag:
+			*v = nil
+		case *commaListFlag:
+			*v = nil
+		}
+	}
+	return flag.CommandLine.Parse(args)
+}
+
+// applyConfigFile sets flags from a YAML or JSON file that maps flag names,
+// without the leading dash, to values. Repeatable flags such as -url and -H
+// take a list. Unknown names are rejected so typos don't go unnoticed.
+func applyConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file (--config): %w", err)
+	}
+
+	// JSON is valid YAML, so one decoder handles both.
+	var settings map[string]any
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("invalid config file (--config) %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("unknown setting %q in config file %s", name, path)
+		}
+
+		values := []any{settings[name]}
+		switch v := settings[name].(type) {
+		case []any:
+			if !isListFlag(f) {
+				return fmt.Errorf("setting %q in config file %s takes a single value, not a list", name, path)
+			}
+			values = v
+		case map[string]any:
+			return fmt.Errorf("setting %q in config file %s must be a value or a list", name, path)
+		case nil:
+			return fmt.Errorf("setting %q in config file %s has no value", name, path)
+		}
+
+		for _, v := range values {
+			if err := flag.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("invalid value for %q in config file %s: %w", name, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isListFlag reports whether f collects repeated values.
+func isListFlag(f *flag.Flag) bool {
+	switch f.Value.(type) {
+	case *listFlag, *commaListFlag:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"go-socket-storm/loadtest"
+)
+
+func TestParseOverConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.yaml")
+	file := "url:\n  - ws://file-a/\n  - ws://file-b/\nH:\n  - \"X-From: file\"\nc: 5\nr: 20\n"
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved loadtest.Config, lists []string) {
+		cfg, commandLineLists = saved, lists
+	}(cfg, commandLineLists)
+
+	args := []string{"-url", "ws://cli-a/", "-url", "ws://cli-b/", "-c", "7"}
+	commandLineLists = nil
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	noteCommandLineLists()
+	if err := parseOverConfigFile(path, args); err != nil {
+		t.Fatal(err)
+	}
+
+	// The command line replaces the file's URLs but leaves its other lists.
+	if want := []string{"ws://cli-a/", "ws://cli-b/"}; !slices.Equal(cfg.URLs, want) {
+		t.Errorf("urls = %q, want %q", cfg.URLs, want)
+	}
+	if want := []string{"X-From: file"}; !slices.Equal(cfg.Headers, want) {
+		t.Errorf("headers = %q, want %q", cfg.Headers, want)
+	}
+	if cfg.Concurrency != 7 || cfg.Rate != 20 {
+		t.Errorf("c = %d, r = %d, want 7, 20", cfg.Concurrency, cfg.Rate)
+	}
+
+	// Parsing again, as a reload does, gives the same lists.
+	if err := parseOverConfigFile(path, args); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ws://cli-a/", "ws://cli-b/"}; !slices.Equal(cfg.URLs, want) {
+		t.Errorf("urls after a reload = %q, want %q", cfg.URLs, want)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"go-socket-storm/loadtest"
+)
+
+// listFlag collects every value of a repeatable flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// commaListFlag collects every value of a repeatable flag such as -url, where
+// each value may also hold several comma-separated entries.
+type commaListFlag []string
+
+func (u *commaListFlag) String() string {
+	return strings.Join(*u, ",")
+}
+
+func (u *commaListFlag) Set(v string) error {
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			*u = append(*u, entry)
+		}
+	}
+	return nil
+}
+
+// fileHeadroom is how many file descriptors are kept free for everything
+// besides connections: stdio, log and CSV files, the metrics listener.
+const fileHeadroom = 64
+
+// flagPassed reports whether the named flag was set on the command line.
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// expandSecretFlags expands $VAR and ${VAR} in the settings of c that carry
+// credentials from the environment, so secrets can stay out of the command
+// line, shell history and config files. $$ stands for a literal $. A
+// variable that isn't set is an error rather than an empty credential. The
+// lists are copied first, so the flags keep what was given.
+func expandSecretFlags(c *loadtest.Config) error {
+	for _, f := range []struct {
+		name string
+		v    *string
+	}{
+		{"bearer", &c.Bearer},
+		{"basic-auth", &c.BasicAuth},
+		{"login-url", &c.LoginURL},
+		{"login-body", &c.LoginBody},
+		{"proxy", &c.Proxy},
+	} {
+		var err error
+		if *f.v, err = expandEnv(f.name, *f.v); err != nil {
+			return err
+		}
+	}
+	for _, l := range []struct {
+		name string
+		v    *[]string
+	}{
+		{"H", &c.Headers},
+		{"cookie", &c.Cookies},
+		{"query", &c.Query},
+	} {
+		*l.v = slices.Clone(*l.v)
+		for i := range *l.v {
+			var err error
+			if (*l.v)[i], err = expandEnv(l.name, (*l.v)[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expandEnv expands the environment variables in the value of the named flag.
+func expandEnv(name, value string) (string, error) {
+	var missing string
+	expanded := os.Expand(value, func(v string) string {
+		if v == "$" {
+			return "$"
+		}
+		s, ok := os.LookupEnv(v)
+		if !ok && missing == "" {
+			missing = v
+		}
+		return s
+	})
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s in --%s is not set; write $$ for a literal $", missing, name)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"go-socket-storm/loadtest"
+)
+
+func TestExpandSecretFlags(t *testing.T) {
+	t.Setenv("TEST_TOKEN", "s3cret")
+	flags := loadtest.Config{
+		Bearer:    "$TEST_TOKEN",
+		BasicAuth: "user:pa$$word",
+		Headers:   []string{"X-Api-Key: ${TEST_TOKEN}"},
+		Cookies:   []string{"session=$TEST_TOKEN"},
+		Query:     []string{"token=$TEST_TOKEN", "plain=1"},
+	}
+	c := flags
+	if err := expandSecretFlags(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Bearer != "s3cret" || c.BasicAuth != "user:pa$word" {
+		t.Errorf("bearer = %q, basicAuth = %q, want s3cret, user:pa$word", c.Bearer, c.BasicAuth)
+	}
+	for _, l := range []struct {
+		name      string
+		got, want []string
+	}{
+		{"headers", c.Headers, []string{"X-Api-Key: s3cret"}},
+		{"cookies", c.Cookies, []string{"session=s3cret"}},
+		{"query", c.Query, []string{"token=s3cret", "plain=1"}},
+	} {
+		if !slices.Equal(l.got, l.want) {
+			t.Errorf("%s = %q, want %q", l.name, l.got, l.want)
+		}
+	}
+	// The flags keep the references, so a reload parsing them again sees
+	// nothing changed.
+	if flags.Bearer != "$TEST_TOKEN" || flags.Query[0] != "token=$TEST_TOKEN" || flags.Headers[0] != "X-Api-Key: ${TEST_TOKEN}" {
+		t.Errorf("flags = %q %q %q, want them unexpanded", flags.Bearer, flags.Query, flags.Headers)
+	}
+}
+
+func TestExpandSecretFlagsUnset(t *testing.T) {
+	c := loadtest.Config{Query: []string{"token=$TEST_UNSET_TOKEN"}}
+	err := expandSecretFlags(&c)
+	if err == nil || !strings.Contains(err.Error(), "TEST_UNSET_TOKEN in --query") {
+		t.Errorf("err = %v, want the unset variable in --query named", err)
+	}
+}
@@ -1,260 +1,320 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
-	"log"
-	"net"
-	"net/url"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
-	"sync"
-	"sync/atomic"
+	"slices"
 	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"go-socket-storm/loadtest"
 )
 
-var (
-	wsUrl       = flag.String("url", "", "WebSocket server URL (e.g., ws://localhost:8080/ws)")
-	concurrency = flag.Int("c", 100, "Total concurrent connections to establish")
-	rate        = flag.Int("r", 10, "New connections per second")
-	duration    = flag.Int("d", 0, "Test duration (e.g., 30s, 5m). If 0, runs until concurrency is reached or interrupted.")
-	verbose     = flag.Bool("v", false, "Enable verbose logging for connection errors")
-)
+// cfg is populated by the command-line flags registered in init.
+var cfg = loadtest.DefaultConfig()
 
 var (
-	successfulConnections int64
-	failedConnections     int64
-	activeConnections     int64
-	totalBytesRead        int64
-)
+	configPath = flag.String("config", "", "YAML or JSON file of flag settings keyed by flag name; command-line flags override it")
+	urlFile    = flag.String("url-file", "", "File of WebSocket URLs to add to --url, one per line; blank lines and # comments are skipped")
 
-var shutdown chan struct{} = make(chan struct{})
+	outputFormat = flag.String("output", "text", "Final summary format: text, or json to also emit a JSON object")
+	outputFile   = flag.String("output-file", "", "Write the JSON summary to this file instead of stdout")
 
-func main() {
-	flag.Parse()
+	patternName   = flag.String("pattern", "constant", "Load pattern: constant, ramp, spike or step")
+	patternPeriod = flag.Duration("pattern-period", time.Minute, "Period the ramp, spike and step patterns are shaped around")
 
-	if *wsUrl == "" {
-		log.Fatal("WebSocket URL (--url) is required")
-	}
-	if *concurrency <= 0 {
-		log.Fatal("Concurrency (--c) must be positive")
-	}
-	if *rate <= 0 {
-		log.Fatal("Rate (--r) must be positive")
-	}
+	logLevel  = flag.String("log-level", "info", "Log level: error, warn, info or debug")
+	logFormat = flag.String("log-format", "text", "Log format: text or json")
+	colorMode = flag.String("color", "auto", "Color the text logs and the dashboard: auto (only on a terminal), always or never")
+	verbose   = flag.Bool("v", false, "Log individual connection events and errors (same as --log-level debug)")
 
-	u, err := url.Parse(*wsUrl)
-	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
-		log.Fatalf("Invalid WebSocket URL: %s. Error: %v", *wsUrl, err)
-	}
+	verboseSample = flag.Int("verbose-sample", 1, "Log only one in this many debug records of each kind, to keep -v usable at high concurrency")
 
-	log.Printf("Starting WebSocket Load Tester:")
-	log.Printf("  URL: %s", *wsUrl)
-	log.Printf("  Total Connections: %d", *concurrency)
-	log.Printf("  Connection Rate: %d/s", *rate)
-	if *duration > 0 {
-		log.Printf("  Test Duration: %d", *duration)
-	} else {
-		log.Printf("  Test Duration: Unlimited (until concurrency reached or interrupted)")
-	}
-	log.Printf("------------------------------------")
+	scenarioPath = flag.String("scenario", "", "YAML or JSON file with a scripted conversation of send, expect and sleep steps for every connection")
+	replayPath   = flag.String("replay", "", "JSON lines file of timestamped messages every connection sends again with their original timing")
 
-	var wg sync.WaitGroup
+	failOnAssert = flag.Bool("fail-on-assert", false, "Exit with status 4 if any received message didn't match --expect")
+	maxErrorRate = flag.Float64("max-error-rate", 1, "Exit with status 3 if more than this fraction (0-1) of connection attempts failed")
+	rampDeadline = flag.Duration("ramp-deadline", 0, "Exit with status 5 if the target concurrency wasn't reached within this long of the start (0 = no deadline)")
 
-	ticker := time.NewTicker(time.Second / time.Duration(*rate))
-	defer ticker.Stop()
+	noRlimit   = flag.Bool("no-rlimit", false, "Don't raise the open-files limit to the hard limit at startup")
+	tui        = flag.Bool("tui", false, "Show a live terminal dashboard instead of periodic status logs (needs a terminal on stderr)")
+	cpuProfile = flag.String("cpuprofile", "", "Write a CPU profile of the load generator itself to this file")
+	memProfile = flag.String("memprofile", "", "Write a heap profile of the load generator to this file when the run ends")
 
-	done := make(chan struct{})
+	dryRun = flag.Bool("dry-run", false, "Validate the flags and log the resolved configuration, then exit without connecting")
+)
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+func init() {
+	flag.Var((*commaListFlag)(&cfg.URLs), "url", "WebSocket server URL (e.g., ws://localhost:8080/ws). Repeatable or comma-separated; append :weight=N to weight a target")
+	flag.StringVar(&cfg.URLSelect, "url-select", cfg.URLSelect, "How workers are spread over several URLs, in proportion to their weights: round-robin or random")
+	flag.IntVar(&cfg.Concurrency, "c", cfg.Concurrency, "Total concurrent connections to establish")
+	flag.IntVar(&cfg.Rate, "r", cfg.Rate, "New connections per second")
+	flag.BoolVar(&cfg.AdaptiveRate, "adaptive-rate", cfg.AdaptiveRate, "Lower the connection rate while the server rejects handshakes with 429, then probe back up to --r")
+	flag.Float64Var(&cfg.AdaptiveThreshold, "adaptive-threshold", cfg.AdaptiveThreshold, "Fraction (0-1) of handshakes per second rejected with 429 that makes --adaptive-rate back off")
+	flag.IntVar(&cfg.GoroutineLimit, "goroutine-limit", cfg.GoroutineLimit, "Hold the ramp-up while the process runs this many goroutines or more (0 = no limit)")
+	flag.Int64Var(&cfg.MaxMessages, "max-messages", cfg.MaxMessages, "Stop the test after this many messages have been sent in total (0 = no limit)")
+	flag.Int64Var(&cfg.MaxBytes, "max-bytes", cfg.MaxBytes, "Stop the test after this many bytes have been read and written in total (0 = no limit)")
+	flag.Float64Var(&cfg.AbortErrorRate, "abort-on-error-rate", cfg.AbortErrorRate, "Abort the test early once more than this fraction (0-1) of connection attempts has failed (0 disables)")
+	flag.Int64Var(&cfg.AbortMinAttempts, "abort-min-attempts", cfg.AbortMinAttempts, "Connection attempts needed before --abort-on-error-rate can trip")
+	flag.IntVar(&cfg.RampDownRate, "ramp-down-rate", cfg.RampDownRate, "Connections closed per second when the test stops (0 = all at once)")
+	flag.Uint64Var(&cfg.Seed, "seed", cfg.Seed, "Seed for jitter and backoff so a run's random choices can be replayed (0 = time-based, logged at startup)")
+	flag.DurationVar(&cfg.HandshakeTimeout, "handshake-timeout", cfg.HandshakeTimeout, "Give up on a dial whose TCP connect, TLS and upgrade take longer than this (0 = no timeout)")
+	flag.DurationVar(&cfg.ConnectTimeout, "connect-timeout", cfg.ConnectTimeout, "Give up on a dial whose TCP connect alone takes longer than this, counted as connectTimeout (0 = only --handshake-timeout applies)")
+	flag.DurationVar(&cfg.Duration, "d", cfg.Duration, "Test duration (e.g., 30s, 5m). If 0, runs until concurrency is reached or interrupted.")
+	flag.DurationVar(&cfg.MaxRuntime, "max-runtime", cfg.MaxRuntime, "Hard limit on the whole run, shutdown included; connections that haven't closed by then are abandoned (0 = no limit)")
+	flag.DurationVar(&cfg.Warmup, "warmup", cfg.Warmup, "Hold connections this long after ramp-up before measuring; latency is not recorded and counters are reported separately")
+	flag.Int64Var(&cfg.MaxMessageSize, "max-message-size", cfg.MaxMessageSize, "Close a connection that receives a message larger than this many bytes (0 = unlimited)")
+	flag.DurationVar(&cfg.SlowRead, "slow-read", cfg.SlowRead, "Pause this long before reading each message, simulating a slow consumer")
+	flag.DurationVar(&cfg.SlowPong, "slow-pong", cfg.SlowPong, "Delay the pong answering each server ping by this long, to test the server's missed-pong handling")
+	flag.DurationVar(&cfg.SlowReadJitter, "slow-read-jitter", cfg.SlowReadJitter, "Randomize each --slow-read pause by up to ± this much")
+	flag.DurationVar(&cfg.CloseTimeout, "close-timeout", cfg.CloseTimeout, "How long a connection closed on shutdown or by --connect-only waits for the server's close frame in reply")
+	flag.DurationVar(&cfg.ReadTimeout, "read-timeout", cfg.ReadTimeout, "Reconnect when nothing, including pongs, is received for this long (0 = never)")
+	flag.DurationVar(&cfg.PingInterval, "ping-interval", cfg.PingInterval, "Ping a connection after it has been idle this long (0 disables pings)")
+	flag.Int64Var(&cfg.BackpressureGap, "backpressure-gap", cfg.BackpressureGap, "Flag a connection as backpressured once it has sent this many more messages than it received (0 disables)")
+	flag.DurationVar(&cfg.IdleTimeout, "idle-timeout", cfg.IdleTimeout, "Close a connection as stalled when nothing is sent or received for this long (0 disables)")
+	flag.DurationVar(&cfg.ConnectionLifetime, "connection-lifetime", cfg.ConnectionLifetime, "Close and reopen each connection after about this long, jittered by --duration-jitter (0 = keep open)")
+	flag.Float64Var(&cfg.LifetimeJitter, "duration-jitter", cfg.LifetimeJitter, "Randomize each --connection-lifetime by up to ± this fraction (0-1) of it, so connections opened together expire spread out (0 = exact lifetimes)")
+	flag.DurationVar(&cfg.Keepalive, "keepalive", cfg.Keepalive, "Send a ping on every connection at this interval regardless of traffic (0 disables)")
+	flag.StringVar(&cfg.HeartbeatMessage, "heartbeat-message", cfg.HeartbeatMessage, "Application-level text message each connection sends every --heartbeat-interval; may be a template like --send")
+	flag.DurationVar(&cfg.HeartbeatInterval, "heartbeat-interval", cfg.HeartbeatInterval, "How often each connection sends --heartbeat-message (0 disables)")
+	flag.BoolVar(&cfg.Soak, "soak", cfg.Soak, "Hold idle connections with minimal overhead: one scheduler sends the --keepalive pings, and --ping-interval and --read-timeout are ignored")
+	flag.IntVar(&cfg.PoolSize, "pool-size", cfg.PoolSize, "With --soak, read all connections on this many goroutines instead of one per connection (Linux, ws:// targets only; 0 = one per connection)")
+	flag.StringVar(&cfg.Send, "send", cfg.Send, "Text payload each connection sends periodically. If empty, connections are read-only.")
+	flag.DurationVar(&cfg.ThinkTime, "think-time", cfg.ThinkTime, "Pause this long between messages on each connection instead of using --send-rate")
+	flag.DurationVar(&cfg.ThinkJitter, "think-jitter", cfg.ThinkJitter, "Randomize each --think-time pause by up to ± this much")
+	flag.StringVar(&cfg.PayloadFile, "payload-file", cfg.PayloadFile, "Send this file's bytes as each message instead of --send")
+	flag.BoolVar(&cfg.Binary, "binary", cfg.Binary, "Send binary frames instead of text frames")
+	flag.IntVar(&cfg.SendRate, "send-rate", cfg.SendRate, "Messages per second each connection sends when --send or --echo is set")
+	flag.BoolVar(&cfg.ReplayLoop, "replay-loop", cfg.ReplayLoop, "Start the --replay sequence over after its last message instead of sending it once")
+	flag.IntVar(&cfg.TargetRPS, "target-rps", cfg.TargetRPS, "Send this many messages per second in total, handed out by a central scheduler to whichever connections are ready, instead of --send-rate each (0 = off)")
+	flag.IntVar(&cfg.TotalSendRate, "total-send-rate", cfg.TotalSendRate, "Cap on messages per second sent across all connections (0 = no cap)")
+	flag.IntVar(&cfg.ReconnectAttempts, "reconnect-attempts", cfg.ReconnectAttempts, "Consecutive failed reconnect attempts before a worker gives up (0 = unlimited)")
+	flag.DurationVar(&cfg.ReconnectDelay, "reconnect-delay", cfg.ReconnectDelay, "Base reconnect delay; doubles after each failed attempt up to --reconnect-max-delay, with full jitter")
+	flag.DurationVar(&cfg.ReconnectMaxDelay, "reconnect-max-delay", cfg.ReconnectMaxDelay, "Upper bound for the exponential reconnect delay")
+	flag.DurationVar(&cfg.RetryUntilTarget, "retry-until-target", cfg.RetryUntilTarget, "Retry failed dials at the --r rate, without backoff, until --c connections are open, for at most this long from the start (0 = off)")
+	flag.BoolVar(&cfg.Precheck, "precheck", cfg.Precheck, "Open one connection to every URL before the ramp-up and exit if any can't be reached or rejects the handshake")
+	flag.BoolVar(&cfg.ConnectOnly, "connect-only", cfg.ConnectOnly, "Benchmark connection setup: close each connection cleanly right after its handshake and dial again at the --r rate")
+	flag.BoolVar(&cfg.NoReconnect, "no-reconnect", cfg.NoReconnect, "Disable reconnection; a failed dial or dropped connection ends the worker")
+	flag.Var((*listFlag)(&cfg.Headers), "H", `Custom handshake header "Key: Value" (repeatable)`)
+	flag.Var((*listFlag)(&cfg.Query), "query", "Query parameter key=value to add to every URL, e.g. token=...; the value is escaped for you (repeatable)")
+	flag.StringVar(&cfg.Origin, "origin", cfg.Origin, `Handshake Origin header (default: derived from each URL, e.g. http://host for ws://host; "none" sends no Origin)`)
+	flag.Var((*listFlag)(&cfg.Cookies), "cookie", `Cookie "name=value" to send with every handshake (repeatable)`)
+	flag.StringVar(&cfg.CookieFile, "cookie-file", cfg.CookieFile, "Netscape-format cookie file (as written by curl -c) whose cookies are sent to the hosts they were saved for")
+	flag.StringVar(&cfg.LoginURL, "login-url", cfg.LoginURL, "HTTP(S) URL to log in at before connecting; the token in the response is sent as a bearer Authorization header")
+	flag.StringVar(&cfg.LoginMethod, "login-method", cfg.LoginMethod, "HTTP method for --login-url (default: POST with --login-body, else GET)")
+	flag.StringVar(&cfg.LoginBody, "login-body", cfg.LoginBody, "Request body for --login-url, sent as JSON if it looks like JSON and as a form otherwise")
+	flag.StringVar(&cfg.LoginTokenPath, "login-token-path", cfg.LoginTokenPath, `Dotted JSON path of the token in the login response, e.g. data.access_token (default "token")`)
+	flag.StringVar(&cfg.LoginTokenRegex, "login-token-regex", cfg.LoginTokenRegex, "Regular expression that finds the token in the login response instead; its capture group, if any, is the token")
+	flag.StringVar(&cfg.Bearer, "bearer", cfg.Bearer, "Bearer token sent as the handshake Authorization header")
+	flag.StringVar(&cfg.BasicAuth, "basic-auth", cfg.BasicAuth, "user:pass sent as a basic-auth handshake Authorization header")
+	flag.Var((*listFlag)(&cfg.Subprotocols), "subprotocol", "WebSocket subprotocol to request, in order of preference (repeatable)")
+	flag.BoolVar(&cfg.Compression, "compression", cfg.Compression, "Offer permessage-deflate compression in the handshake")
+	flag.IntVar(&cfg.CompressionLevel, "compression-level", cfg.CompressionLevel, "Compression level for sent messages when compression is negotiated, -2 (Huffman only) to 9 (best)")
+	flag.Var((*commaListFlag)(&cfg.LocalAddrs), "local-addr", "Source IP to dial from. Repeatable or comma-separated; workers are spread across the addresses round-robin")
+	flag.StringVar(&cfg.Proxy, "proxy", cfg.Proxy, "HTTP or SOCKS5 proxy URL for the handshake (default: HTTP_PROXY/HTTPS_PROXY from the environment)")
+	flag.BoolVar(&cfg.InsecureSkipVerify, "insecure-skip-verify", cfg.InsecureSkipVerify, "Skip TLS certificate verification for wss:// targets (insecure)")
+	flag.StringVar(&cfg.CACert, "ca-cert", cfg.CACert, "PEM CA bundle used to verify wss:// server certificates")
+	flag.StringVar(&cfg.ClientCert, "client-cert", cfg.ClientCert, "PEM client certificate for mutual TLS (requires --client-key)")
+	flag.StringVar(&cfg.ClientKey, "client-key", cfg.ClientKey, "PEM private key for --client-cert")
+	flag.StringVar(&cfg.SNI, "sni", cfg.SNI, "Server name sent in the TLS handshake and checked against the certificate instead of the URL host, e.g. when dialing a load balancer by IP")
+	flag.StringVar(&cfg.TLSMinVersion, "tls-min-version", cfg.TLSMinVersion, "Lowest TLS version to offer: 1.0, 1.1, 1.2 or 1.3 (default: the Go default, 1.2)")
+	flag.StringVar(&cfg.TLSMaxVersion, "tls-max-version", cfg.TLSMaxVersion, "Highest TLS version to offer: 1.0, 1.1, 1.2 or 1.3 (default 1.3)")
+	flag.Var((*commaListFlag)(&cfg.CipherSuites), "cipher-suites", "TLS 1.2 and earlier cipher suites to offer, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Repeatable or comma-separated")
+	flag.DurationVar(&cfg.StatsInterval, "stats-interval", cfg.StatsInterval, "How often to print periodic stats (0 disables them)")
+	flag.DurationVar(&cfg.RateWindow, "rate-window", cfg.RateWindow, "Also log the rates and error rate averaged over about this long, e.g. 10s, with each status record (0 = off)")
+	flag.BoolVar(&cfg.Quiet, "quiet", cfg.Quiet, "Don't log periodic stats; the final summary is still logged")
+	flag.StringVar(&cfg.CSVPath, "csv", cfg.CSVPath, "Append a row of stats to this CSV file every stats interval")
+	flag.StringVar(&cfg.ReportPath, "report", cfg.ReportPath, "Write an HTML report with charts of the stats collected every stats interval to this file")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "Serve Prometheus metrics on this address (e.g., :9090) during the run")
+	flag.BoolVar(&cfg.CompressOutput, "compress-output", cfg.CompressOutput, "Compress the --csv, --report and --events files whose names end in .gz with gzip, or .zst with zstd")
+	flag.StringVar(&cfg.EventsPath, "events", cfg.EventsPath, "Write a JSON line per connection and message event to this file as it happens (- for stdout)")
+	flag.StringVar(&cfg.Expect, "expect", cfg.Expect, "Regular expression (or plain substring) every received text message should match; mismatches are counted as assertion failures")
+	flag.BoolVar(&cfg.Echo, "echo", cfg.Echo, "Send sequence-numbered payloads and measure round-trip latency against an echo server")
+	flag.StringVar(&cfg.CorrelationField, "correlate", cfg.CorrelationField, "Add a request id under this JSON field to every message sent and measure the latency of the response carrying it")
+	flag.DurationVar(&cfg.ResponseTimeout, "response-timeout", cfg.ResponseTimeout, "How long a --correlate request may wait for its response before it counts as a timeout")
+}
 
-	go func() {
-		<-sigChan
-		log.Println("\nShutdown signal received, stopping workers...")
-		close(shutdown)
+func main() {
+	flag.Parse()
 
-		if *duration > 0 {
-			close(done)
+	if *configPath != "" {
+		noteCommandLineLists()
+		if err := parseOverConfigFile(*configPath, os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-	}()
-
-	if *duration > 0 {
-
-		go func() {
-			time.Sleep(time.Duration(*duration))
-			log.Println("\nTest duration reached, stopping workers...")
-			close(shutdown)
-			close(done)
-		}()
 	}
 
-	go printStats()
-
-	establishedConnections := 0
-	startTime := time.Now()
-
-	for establishedConnections < *concurrency {
-		select {
-		case <-ticker.C:
-			wg.Add(1)
-			go worker(*wsUrl, &wg)
-			establishedConnections++
-		case <-shutdown:
-			log.Printf("Stopping connection ramp-up due to shutdown signal.")
-			goto endLoop
-
-		}
+	// run is what the test is started with. It is kept apart from cfg,
+	// which holds only what the flags say, so a reload that parses them
+	// again finds the same values and reports no changes.
+	run := cfg
+	if err := expandSecretFlags(&run); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-endLoop:
-	if *duration > 0 {
-		log.Printf("Reached target connection count (%d). Waiting for test duration (%d) or interrupt...", establishedConnections, *duration)
-		<-done
-	} else {
-		log.Printf("Reached target connection count (%d). Waiting for interrupt (Ctrl+C)...", establishedConnections)
-
-		select {
-		case <-shutdown:
-
-		default:
 
-			<-shutdown
+	if *verbose {
+		*logLevel = "debug"
+	}
+	level, err := parseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	levelVar.Set(level)
+	color, err := parseColor(*colorMode)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logger, err := newLogger(os.Stderr, *logFormat, *verboseSample, color)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	run.Logger = logger
+
+	if *tui {
+		if isTerminal(os.Stderr) {
+			run.Dashboard = os.Stderr
+			run.Color = color
+		} else {
+			slog.Warn("stderr is not a terminal, ignoring --tui")
 		}
 	}
 
-	log.Println("Waiting for active connections to close...")
-	wg.Wait()
-	endTime := time.Now()
-
-	log.Println("------------------------------------")
-	log.Printf("Test Finished.")
-	log.Printf("Duration: %s", endTime.Sub(startTime).Round(time.Millisecond))
-	log.Printf("Successful Connections: %d", atomic.LoadInt64(&successfulConnections))
-	log.Printf("Failed Connections: %d", atomic.LoadInt64(&failedConnections))
-	log.Printf("Total Bytes Read: %d", atomic.LoadInt64(&totalBytesRead))
+	if *outputFormat != "text" && *outputFormat != "json" {
+		fatal("Invalid output format (--output). Must be text or json", "output", *outputFormat)
+	}
+	if run.EventsPath == "-" && *outputFormat == "json" && *outputFile == "" {
+		fatal("--events - and --output json both write to stdout; set --output-file")
+	}
 
-}
+	if flagPassed("duration-jitter") && run.ConnectionLifetime == 0 {
+		fatal("--duration-jitter requires --connection-lifetime")
+	}
+	if run.ThinkTime > 0 && flagPassed("send-rate") {
+		fatal("--think-time and --send-rate are mutually exclusive")
+	}
+	if run.TargetRPS > 0 && flagPassed("send-rate") {
+		fatal("--target-rps and --send-rate are mutually exclusive")
+	}
 
-func worker(url string, wg *sync.WaitGroup) {
-	defer wg.Done()
+	if *maxErrorRate < 0 || *maxErrorRate > 1 {
+		fatal("Invalid error rate (--max-error-rate). Must be between 0 and 1", "maxErrorRate", *maxErrorRate)
+	}
+	if *rampDeadline < 0 {
+		fatal("Invalid ramp deadline (--ramp-deadline). Must not be negative", "rampDeadline", *rampDeadline)
+	}
+	if *rampDeadline > 0 && run.ConnectOnly {
+		fatal("--ramp-deadline can't be used with --connect-only, which never holds connections open")
+	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Recovered from panic in worker: %v", r)
+	if *urlFile != "" {
+		urls, err := loadtest.LoadURLFile(*urlFile)
+		if err != nil {
+			fatal("Failed to load URL file (--url-file)", "err", err)
 		}
-	}()
+		run.URLs = slices.Concat(run.URLs, urls)
+	}
 
-	const maxReconnectAttempts = 0 // 0 = unlimited
-	const reconnectDelay = 2 * time.Second
-
-	var reconnectAttempts int
-	var conn *websocket.Conn
-	var err error
-
-reconnectLoop:
-	for {
-		select {
-		case <-shutdown:
-			if *verbose {
-				log.Println("Worker skipping connection due to shutdown signal.")
-			}
-			return
-		default:
+	if *patternName != "constant" {
+		pattern, err := loadtest.NewPattern(*patternName, run.Concurrency, *patternPeriod)
+		if err != nil {
+			fatal("Invalid load pattern (--pattern)", "err", err)
 		}
+		run.Pattern = pattern
+	}
 
-		conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+	if *scenarioPath != "" {
+		scenario, err := loadtest.LoadScenario(*scenarioPath)
 		if err != nil {
-			atomic.AddInt64(&failedConnections, 1)
-			if *verbose {
-				log.Printf("Connection failed: %v", err)
-			}
-			if maxReconnectAttempts > 0 && reconnectAttempts >= maxReconnectAttempts {
-				return
-			}
-			reconnectAttempts++
-			time.Sleep(reconnectDelay)
-			continue reconnectLoop
+			fatal("Failed to load scenario (--scenario)", "err", err)
 		}
-
-		break
+		run.Scenario = scenario
+	}
+	if *replayPath != "" {
+		replay, err := loadtest.LoadReplay(*replayPath)
+		if err != nil {
+			fatal("Failed to load replay (--replay)", "err", err)
+		}
+		run.Replay = replay
 	}
 
-	atomic.AddInt64(&successfulConnections, 1)
-	atomic.AddInt64(&activeConnections, 1)
-	defer atomic.AddInt64(&activeConnections, -1)
-	defer conn.Close()
-
-	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-
-	for {
-		select {
-		case <-shutdown:
-			if *verbose {
-				log.Printf("Worker [%s] received shutdown. Closing connection.", conn.LocalAddr())
-			}
-			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-			time.Sleep(500 * time.Millisecond)
-			return
-		default:
+	if *dryRun {
+		if err := loadtest.Check(run); err != nil {
+			fatal(err.Error())
 		}
+		slog.Info("Configuration is valid (--dry-run), not connecting")
+		return
+	}
 
-		messageType, p, err := conn.ReadMessage()
+	raiseFileLimit(run.Concurrency, !*noRlimit)
 
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) ||
-				websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-				if *verbose {
-					log.Printf("Worker [%s] connection closed: %v", conn.LocalAddr(), err)
-				}
-			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				err = conn.WriteMessage(websocket.PingMessage, nil)
-				if err != nil {
-					if *verbose {
-						log.Printf("Worker [%s] ping failed: %v", conn.LocalAddr(), err)
-					}
-					goto reconnectLoop
-				}
-				conn.SetReadDeadline(time.Now().Add(10 * time.Second))
-				continue
-			} else {
-				if *verbose {
-					log.Printf("Worker [%s] unhandled error: %v", conn.LocalAddr(), err)
-				}
-			}
-			goto reconnectLoop
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		atomic.AddInt64(&totalBytesRead, int64(len(p)))
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-		if *verbose && messageType == websocket.TextMessage {
-			log.Printf("Worker [%s] received: %s", conn.LocalAddr(), string(p))
-		}
+	go func() {
+		<-sigChan
+		slog.Info("Shutdown signal received, stopping workers")
+		cancel()
+	}()
+
+	run.Pause = pauseSignals()
+	run.StatusRequests = statusSignals()
 
-		if messageType == websocket.PongMessage && *verbose {
-			log.Printf("Worker [%s] received Pong", conn.LocalAddr())
+	stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
+	if err != nil {
+		fatal(err.Error())
+	}
+	stopReloads := func() {}
+	if *configPath != "" {
+		stopReloads = handleReloads(&run)
+	}
+
+	summary, err := loadtest.Run(ctx, run)
+	stopReloads()
+	stopProfiles()
+	if err != nil {
+		fatal(err.Error())
+	}
+
+	if *outputFormat == "json" {
+		if err := writeJSON(summary, *outputFile); err != nil {
+			fatal("Failed to write JSON summary", "err", err)
 		}
+	}
 
-		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if status := outcomeStatus(summary); status != 0 {
+		os.Exit(status)
 	}
 }
 
-func printStats() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			log.Printf("Status => Active: %d, Succeeded: %d, Failed: %d, BytesRead: %d",
-				atomic.LoadInt64(&activeConnections),
-				atomic.LoadInt64(&successfulConnections),
-				atomic.LoadInt64(&failedConnections),
-				atomic.LoadInt64(&totalBytesRead),
-			)
-		case <-shutdown:
-			return
-		}
+// writeJSON writes the summary to path, or to stdout if path is empty.
+func writeJSON(summary loadtest.Summary, path string) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("writing summary to %s: %w", path, err)
 	}
+	return nil
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-socket-storm/loadtest"
+)
+
+func TestWriteJSON(t *testing.T) {
+	summary := loadtest.Summary{
+		DurationMs:  1500,
+		Attempts:    12,
+		Successful:  10,
+		Failed:      2,
+		ActiveAtEnd: 9,
+		BytesRead:   4096,
+		HandshakeLatency: &loadtest.LatencyStats{
+			Count: 10,
+			Min:   time.Millisecond,
+			P99:   2500 * time.Microsecond,
+		},
+	}
+	path := filepath.Join(t.TempDir(), "summary.json")
+	if err := writeJSON(summary, path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("summary isn't JSON: %v\n%s", err, data)
+	}
+	for key, want := range map[string]float64{
+		"durationMs":  1500,
+		"attempts":    12,
+		"successful":  10,
+		"failed":      2,
+		"activeAtEnd": 9,
+		"bytesRead":   4096,
+	} {
+		if v, ok := got[key].(float64); !ok || v != want {
+			t.Errorf("%s = %v, want %v", key, got[key], want)
+		}
+	}
+	latency, ok := got["handshakeLatency"].(map[string]any)
+	if !ok {
+		t.Fatalf("handshakeLatency = %v, want an object", got["handshakeLatency"])
+	}
+	for key, want := range map[string]float64{"count": 10, "minMs": 1, "p99Ms": 2.5} {
+		if v, ok := latency[key].(float64); !ok || v != want {
+			t.Errorf("handshakeLatency.%s = %v, want %v", key, latency[key], want)
+		}
+	}
+	// Latencies that weren't measured are left out.
+	if _, ok := got["echoLatency"]; ok {
+		t.Errorf("echoLatency = %v, want it omitted", got["echoLatency"])
+	}
+}
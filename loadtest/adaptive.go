@@ -0,0 +1,53 @@
+package loadtest
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// adaptInterval is how often the adaptive rate is reconsidered.
+const adaptInterval = time.Second
+
+// adaptStep is the share of Config.Rate the adaptive rate climbs by in each
+// interval without rate limiting.
+const adaptStep = 0.1
+
+// rateController backs the connection rate off when the server answers
+// too many handshakes with 429 Too Many Requests, halving it each interval
+// that happens and probing back up towards Config.Rate otherwise. It is
+// only used by the run loop.
+type rateController struct {
+	limiter   *rate.Limiter
+	max       float64
+	threshold float64
+
+	prevAttempts, prevRejected int64
+}
+
+func newRateController(limiter *rate.Limiter, max int, threshold float64) *rateController {
+	return &rateController{limiter: limiter, max: float64(max), threshold: threshold}
+}
+
+// adjust looks at the handshakes since the last call and updates the
+// limiter. It returns the old and new rate, and the attempts and 429s the
+// decision was based on.
+func (c *rateController) adjust(r *runner) (from, to float64, attempts, rejected int64) {
+	total := atomic.LoadInt64(&r.stats.successful) + atomic.LoadInt64(&r.stats.failed)
+	total429 := r.statusCodes.Snapshot()["429"]
+	attempts, rejected = total-c.prevAttempts, total429-c.prevRejected
+	c.prevAttempts, c.prevRejected = total, total429
+
+	from = float64(c.limiter.Limit())
+	to = from
+	switch {
+	case attempts > 0 && float64(rejected) > c.threshold*float64(attempts):
+		to = math.Max(1, math.Floor(from/2))
+	case from < c.max:
+		to = math.Min(c.max, from+math.Max(1, math.Round(c.max*adaptStep)))
+	}
+	c.limiter.SetLimit(rate.Limit(to))
+	return from, to, attempts, rejected
+}
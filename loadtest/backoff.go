@@ -0,0 +1,52 @@
+package loadtest
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff produces reconnect delays that double from base up to max, with
+// full jitter: each delay is drawn uniformly from [0, ceiling]. Jitter keeps
+// workers that lost their connections at the same moment from redialing in
+// lockstep.
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	attempt int
+	rng     *rand.Rand
+}
+
+func newBackoff(base, max time.Duration, rng *rand.Rand) *backoff {
+	if max < base {
+		max = base
+	}
+	return &backoff{base: base, max: max, rng: rng}
+}
+
+// ceiling returns the upper bound for the current attempt without jitter.
+func (b *backoff) ceiling() time.Duration {
+	d := b.base
+	for i := 0; i < b.attempt && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max || d <= 0 {
+		d = b.max
+	}
+	return d
+}
+
+// Next returns the delay to wait before the next attempt and advances the
+// backoff.
+func (b *backoff) Next() time.Duration {
+	ceiling := b.ceiling()
+	b.attempt++
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(b.rng.Int64N(int64(ceiling) + 1))
+}
+
+// Reset starts the backoff over from base, e.g. after a successful connect.
+func (b *backoff) Reset() {
+	b.attempt = 0
+}
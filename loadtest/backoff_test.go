@@ -0,0 +1,71 @@
+package loadtest
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestBackoffCeiling(t *testing.T) {
+	tests := []struct {
+		name      string
+		base, max time.Duration
+		// want are the ceilings of the first attempts in turn.
+		want []time.Duration
+	}{
+		{
+			name: "doubles up to the cap",
+			base: 100 * time.Millisecond, max: time.Second,
+			want: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			name: "cap below base",
+			base: time.Second, max: 10 * time.Millisecond,
+			want: []time.Duration{time.Second, time.Second},
+		},
+		{
+			name: "no delay",
+			want: []time.Duration{0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(tt.base, tt.max, rand.New(rand.NewPCG(1, 2)))
+			for i, want := range tt.want {
+				if got := b.ceiling(); got != want {
+					t.Errorf("attempt %d: ceiling = %s, want %s", i, got, want)
+				}
+				if d := b.Next(); d < 0 || d > want {
+					t.Errorf("attempt %d: delay %s outside [0, %s]", i, d, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffManyAttemptsStayCapped(t *testing.T) {
+	// Doubling this often would overflow a time.Duration.
+	b := newBackoff(time.Second, time.Minute, rand.New(rand.NewPCG(1, 2)))
+	for range 100 {
+		if d := b.Next(); d > time.Minute {
+			t.Fatalf("delay %s above the cap", d)
+		}
+	}
+	if got := b.ceiling(); got != time.Minute {
+		t.Errorf("ceiling = %s, want %s", got, time.Minute)
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	b := newBackoff(100*time.Millisecond, time.Second, rand.New(rand.NewPCG(1, 2)))
+	for range 5 {
+		b.Next()
+	}
+	b.Reset()
+	if got := b.ceiling(); got != 100*time.Millisecond {
+		t.Errorf("ceiling after Reset = %s, want the base", got)
+	}
+	if d := b.Next(); d > 100*time.Millisecond {
+		t.Errorf("delay after Reset = %s, want at most the base", d)
+	}
+}
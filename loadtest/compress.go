@@ -0,0 +1,85 @@
+package loadtest
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressor is a compressing writer: gzip.Writer or zstd.Encoder.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressedExt reports whether path ends in an extension
+// Config.CompressOutput compresses.
+func compressedExt(path string) bool {
+	return strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".zst")
+}
+
+// outputFile is one of the files a run writes its results to, compressed
+// with Config.CompressOutput when its name asks for it.
+type outputFile struct {
+	f *os.File
+	z compressor // nil if uncompressed
+}
+
+// createOutput creates path. With compress set, a path ending in .gz is
+// written through gzip and one ending in .zst through zstd; any other path
+// is written as is.
+func createOutput(path string, compress bool) (*outputFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &outputFile{f: f}
+	switch {
+	case !compress:
+	case strings.HasSuffix(path, ".gz"):
+		o.z = gzip.NewWriter(f)
+	case strings.HasSuffix(path, ".zst"):
+		// zstd compresses on goroutines of its own unless told not to,
+		// which would only compete with the connections for CPU.
+		if o.z, err = zstd.NewWriter(f, zstd.WithEncoderConcurrency(1)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func (o *outputFile) Name() string { return o.f.Name() }
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	if o.z != nil {
+		return o.z.Write(p)
+	}
+	return o.f.Write(p)
+}
+
+// Flush pushes what has been written so far through the compressor, so a
+// reader decompressing the file while it grows sees it.
+func (o *outputFile) Flush() error {
+	if o.z != nil {
+		return o.z.Flush()
+	}
+	return nil
+}
+
+// Close ends the compressed stream, without which it would be truncated,
+// and closes the file.
+func (o *outputFile) Close() error {
+	var err error
+	if o.z != nil {
+		err = o.z.Close()
+	}
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
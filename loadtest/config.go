@@ -0,0 +1,618 @@
+package loadtest
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Config describes a load test run. Start from DefaultConfig and override
+// what you need; the zero value is not a valid configuration.
+type Config struct {
+	// URLs are the WebSocket endpoints under test. An entry may end in
+	// ":weight=N" to receive a larger share of workers.
+	URLs []string
+	// URLSelect is how workers are spread over URLs: "round-robin" (the
+	// default) or "random", both in proportion to the weights.
+	URLSelect string
+	// Query are "key=value" parameters added to the query string of every
+	// URL, such as an auth token. They are given unescaped and encoded
+	// here; logs show only their keys.
+	Query []string
+	// Concurrency is the total number of connections to establish. With a
+	// Pattern it is the peak the pattern is capped at.
+	Concurrency int
+	// RampDownRate is how many connections per second are closed once the
+	// test stops. Zero closes them all at once.
+	RampDownRate int
+	// Pattern varies the number of open connections over the run. Nil holds
+	// Concurrency connections (ConstantPattern).
+	Pattern Pattern
+	// Rate is the number of new connections started per second.
+	Rate int
+	// AdaptiveRate lowers the connection rate below Rate while more than
+	// AdaptiveThreshold of the handshakes in a second are rejected with 429
+	// Too Many Requests, and raises it back towards Rate once they aren't.
+	AdaptiveRate      bool
+	AdaptiveThreshold float64
+	// GoroutineLimit holds the ramp-up while the process runs this many
+	// goroutines or more, so a test too big for the machine levels off
+	// instead of exhausting its memory. Each connection takes two
+	// goroutines with the defaults and up to five with keepalive pings,
+	// sending and IdleTimeout in use, one with Soak and none with PoolSize.
+	// Zero means no limit.
+	GoroutineLimit int
+	// Duration bounds the test. Zero runs until ctx is cancelled.
+	Duration time.Duration
+	// MaxRuntime is a hard limit on the whole run, shutdown included. Once
+	// it has passed, Run stops waiting for connections still closing and
+	// returns without them; a run that hasn't been stopped by then is
+	// stopped, with a little over CloseTimeout to close its connections.
+	// Zero means no limit.
+	MaxRuntime time.Duration
+	// MaxMessages and MaxBytes stop the test once that many messages have
+	// been sent, or bytes read and written, across all connections. Zero
+	// means no limit. Whichever stop condition is met first wins.
+	MaxMessages int64
+	MaxBytes    int64
+	// AbortErrorRate aborts the test once more than this fraction of
+	// connection attempts has failed, but not before AbortMinAttempts
+	// attempts have been made. Zero disables it.
+	AbortErrorRate   float64
+	AbortMinAttempts int64
+	// Warmup is how long to hold the connections after ramp-up before the
+	// measurement window opens. Latencies are not recorded during it, and the
+	// summary reports its counters separately. It counts towards Duration.
+	Warmup time.Duration
+	// HandshakeTimeout bounds the TCP connect, TLS and WebSocket upgrade of
+	// each dial. Zero means no timeout.
+	HandshakeTimeout time.Duration
+	// ConnectTimeout bounds just the TCP connect of each dial, so an
+	// unresponsive address fails fast while the upgrade may take up to
+	// HandshakeTimeout. Zero leaves it to HandshakeTimeout.
+	ConnectTimeout time.Duration
+	// Pause, if set, pauses the opening of new connections when true is
+	// received and resumes it on false. Open connections keep running.
+	Pause <-chan bool
+	// Reload, if set, applies the settings received on it to the running
+	// test; see LiveConfig.
+	Reload <-chan LiveConfig
+	// StatusRequests, if set, logs a status record with the current stats
+	// on every receive, on top of the periodic ones.
+	StatusRequests <-chan struct{}
+	// Seed makes the run's random choices, such as jitter and reconnect
+	// backoff, reproducible: each worker draws from its own stream derived
+	// from Seed and its id. Zero picks a time-based seed, which is logged.
+	Seed uint64
+	// Logger receives the run's log output: progress and periodic stats at
+	// Info, individual connection events and errors at Debug. Nil uses
+	// slog.Default().
+	Logger *slog.Logger
+
+	// MaxMessageSize is the largest message a connection accepts, in
+	// bytes. A larger one closes the connection with a protocol error
+	// rather than being buffered. Zero means no limit.
+	MaxMessageSize int64
+
+	// SlowRead pauses this long, ±SlowReadJitter, before reading each
+	// message, simulating a client that can't keep up with the server.
+	SlowRead       time.Duration
+	SlowReadJitter time.Duration
+	// SlowPong delays the pong answering each server ping by this long, to
+	// test how a server treats clients whose pongs arrive late or, past its
+	// deadline, not at all. Zero answers at once.
+	SlowPong time.Duration
+
+	// CloseTimeout is how long a connection closed by the client, on
+	// shutdown or with ConnectOnly, waits for the server to answer its
+	// close frame before it is torn down anyway.
+	CloseTimeout time.Duration
+	// ReadTimeout reconnects a connection when nothing, pongs included, is
+	// received for this long. Zero disables it.
+	ReadTimeout time.Duration
+	// PingInterval pings a connection once it has been idle this long.
+	// Zero disables idle pings.
+	PingInterval time.Duration
+	// IdleTimeout closes a connection as stalled when it has neither
+	// received a frame nor sent a message for this long. Pings sent don't
+	// count, pongs received do. Zero disables it.
+	IdleTimeout time.Duration
+	// BackpressureGap flags a connection as backpressured once it has sent
+	// this many more messages than it has received, a sign that the server
+	// is queueing what it can't keep up with. It assumes the server answers
+	// every message, as an echo server does. Zero disables it.
+	BackpressureGap int64
+	// ConnectionLifetime closes and reopens each connection after roughly
+	// this long to churn the server's accept and teardown path. Zero keeps
+	// connections open.
+	ConnectionLifetime time.Duration
+	// LifetimeJitter randomizes each ConnectionLifetime by up to ± this
+	// fraction of it, so connections opened together don't all expire
+	// together. Zero gives every connection exactly ConnectionLifetime.
+	LifetimeJitter float64
+	// Keepalive pings every connection at this interval regardless of
+	// traffic. Zero disables it.
+	Keepalive time.Duration
+	// HeartbeatMessage is an application-level text message every
+	// connection sends each HeartbeatInterval, for servers whose idle
+	// timeout ping frames don't satisfy. Like Send it may be a template,
+	// with Seq counting the connection's heartbeats. Heartbeats aren't
+	// counted as MessagesSent and don't take TotalSendRate tokens.
+	HeartbeatMessage  string
+	HeartbeatInterval time.Duration
+	// Soak holds idle connections as cheaply as possible: one scheduler
+	// sends every Keepalive ping instead of a goroutine per connection, and
+	// PingInterval and ReadTimeout are ignored, so nothing but the server
+	// closing or the network failing ends a connection. It can't be
+	// combined with sending.
+	Soak bool
+	// PoolSize, with Soak, holds the connections on this many goroutines
+	// that each wait for any of their share to become readable, in place of
+	// a goroutine per connection, for tests of 100k connections and more.
+	// It needs Linux and ws:// or Unix socket targets, as TLS connections
+	// can't be read this way, and can't be combined with IdleTimeout,
+	// ConnectionLifetime, heartbeats, SlowRead or SlowPong. Zero gives
+	// every connection a goroutine of its own.
+	PoolSize int
+	// ConnectOnly benchmarks connection setup: each connection is closed
+	// cleanly as soon as its handshake completes, and the worker dials again
+	// as soon as the Rate allows, so Rate is the number of handshakes per
+	// second and Concurrency caps how many are in flight. With NoReconnect
+	// each worker connects once. PingInterval and Keepalive are ignored.
+	ConnectOnly bool
+	// RetryUntilTarget, if set, keeps the ramp-up going until Concurrency
+	// connections are open, for at most this long from the start: a worker
+	// whose dials have all failed so far takes its next turn in the Rate
+	// bucket and dials again right away, as a fresh worker would, instead
+	// of backing off, giving up with NoReconnect or counting towards
+	// ReconnectAttempts. Rate then paces attempts rather than workers.
+	// Once the target is reached or the time is up, workers reconnect as
+	// usual.
+	RetryUntilTarget time.Duration
+	// Precheck opens a single connection to every URL before the ramp-up
+	// and fails the run if one of them can't be reached or rejects the
+	// handshake.
+	Precheck bool
+
+	// Send is a text payload each connection writes every 1/SendRate
+	// seconds. Empty means connections are read-only unless PayloadFile or
+	// Echo is set.
+	Send     string
+	SendRate int
+	// TotalSendRate caps the messages per second sent across all
+	// connections, on top of each connection's own pace, so the offered
+	// load doesn't grow with the connection count. Zero means no cap.
+	TotalSendRate int
+	// TargetRPS, if set, replaces SendRate with a central scheduler that
+	// hands out this many send permits per second to whichever
+	// connections are ready, keeping the aggregate message rate steady as
+	// connections come and go.
+	TargetRPS int
+	// ThinkTime, if set, paces sends by pausing this long, ±ThinkJitter,
+	// after each message instead of sending at SendRate.
+	ThinkTime   time.Duration
+	ThinkJitter time.Duration
+	// PayloadFile is sent instead of Send; the file is read once at
+	// startup. "-" reads the payload from stdin.
+	PayloadFile string
+	// Binary sends binary frames instead of text frames.
+	Binary bool
+	// Echo sends sequence-numbered payloads and measures their round trip.
+	Echo bool
+	// CorrelationField turns on request/response correlation for RPC
+	// servers. Every message sent must be a JSON object and gets a unique
+	// numeric id added under this field; the received JSON object carrying
+	// the same id is its response. Requests not answered within
+	// ResponseTimeout count as timeouts.
+	CorrelationField string
+	ResponseTimeout  time.Duration
+	// Expect, if set, is a regular expression every received text message
+	// should match; those that don't count as assertion failures.
+	Expect string
+	// Scenario, if set, is a scripted conversation each connection runs
+	// instead of sending Send or PayloadFile.
+	Scenario *Scenario
+	// Replay, if set, is a captured message sequence each connection
+	// sends with its original timing instead of Send or PayloadFile.
+	// ReplayLoop starts it over after the last message.
+	Replay     *Replay
+	ReplayLoop bool
+
+	// ReconnectAttempts is the number of consecutive failed reconnects
+	// before a worker gives up. Zero means unlimited.
+	ReconnectAttempts int
+	// ReconnectDelay is the base of the exponential reconnect backoff,
+	// capped at ReconnectMaxDelay.
+	ReconnectDelay    time.Duration
+	ReconnectMaxDelay time.Duration
+	// NoReconnect ends a worker on its first failed dial or dropped
+	// connection.
+	NoReconnect bool
+
+	// Headers are extra handshake headers in "Key: Value" form.
+	Headers []string
+	// Origin is the handshake Origin header. When empty, each URL gets the
+	// origin a browser on that host would send, http:// for ws:// and
+	// https:// for wss://; "none" sends no Origin at all. An Origin given
+	// in Headers is sent as is.
+	Origin string
+	// Cookies are "name=value" cookies sent in every handshake, and
+	// CookieFile a Netscape cookie file, as curl -c writes, whose cookies
+	// are sent to the hosts and paths they were saved for. Cookies the
+	// server sets in a handshake response are kept for later handshakes.
+	Cookies    []string
+	CookieFile string
+	// LoginURL, if set, is requested once before the first connection with
+	// LoginMethod (GET, or POST when LoginBody is set) and LoginBody. The
+	// token in its response, found by LoginTokenRegex or LoginTokenPath
+	// (a dotted JSON path, "token" by default), is sent in every handshake
+	// as a bearer Authorization header. The run fails if the login does.
+	LoginURL        string
+	LoginMethod     string
+	LoginBody       string
+	LoginTokenPath  string
+	LoginTokenRegex string
+	// Bearer and BasicAuth ("user:pass") set the Authorization header.
+	Bearer    string
+	BasicAuth string
+	// Subprotocols are requested in order of preference.
+	Subprotocols []string
+
+	// Compression offers permessage-deflate in the handshake. Where the
+	// server accepts it, messages are written at CompressionLevel, from -2
+	// (Huffman only) to 9 (best compression).
+	Compression      bool
+	CompressionLevel int
+
+	// LocalAddrs are source IPs to dial from. Workers are spread across
+	// them round-robin, each keeping its address across reconnects.
+	LocalAddrs []string
+	// Proxy is an http:// or socks5:// proxy URL. Empty uses the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy string
+
+	InsecureSkipVerify bool
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	// SNI is the server name sent in the TLS handshake and verified
+	// against the server's certificate, instead of the URL host. Empty
+	// uses the host.
+	SNI string
+	// TLSMinVersion and TLSMaxVersion bound the TLS versions offered, e.g.
+	// "1.3" for both to only offer TLS 1.3. Empty keeps the crypto/tls
+	// defaults.
+	TLSMinVersion string
+	TLSMaxVersion string
+	// CipherSuites restricts the TLS 1.2 and earlier cipher suites offered
+	// to these, named as crypto/tls names them.
+	CipherSuites []string
+
+	// StatsInterval is how often periodic stats are logged. Zero disables
+	// them.
+	StatsInterval time.Duration
+	// RateWindow, if set, adds the rates and error rate averaged over
+	// about this long to each status record, smoothing out the spikes of
+	// rates taken over a single StatsInterval.
+	RateWindow time.Duration
+	// Dashboard, if set, is a terminal that shows a live dashboard,
+	// redrawn a few times a second with ANSI escape codes, instead of the
+	// periodic stats records. Log records are shown in its event panel
+	// until the run ends.
+	Dashboard io.Writer
+	// Color highlights the dashboard's title, failures and warning and
+	// error records with ANSI colors.
+	Color bool
+	// Quiet suppresses the periodic stats records while still writing CSV
+	// rows and logging the final summary.
+	Quiet bool
+	// CSVPath, if set, receives one row of stats per StatsInterval.
+	CSVPath string
+	// ReportPath, if set, receives an HTML report with charts of the stats
+	// collected every StatsInterval, written when the run ends.
+	ReportPath string
+	// MetricsAddr, if set, serves Prometheus metrics during the run.
+	MetricsAddr string
+	// EventsPath, if set, receives a JSON line for every connection opened,
+	// failed or closed, message received and assertion failed, as they
+	// happen. "-" writes them to stdout.
+	EventsPath string
+	// CompressOutput compresses those of CSVPath, ReportPath and
+	// EventsPath that end in .gz with gzip and those ending in .zst with
+	// zstd.
+	CompressOutput bool
+}
+
+// LiveConfig holds the settings that can be changed while a test runs, by
+// sending on Config.Reload. Everything else is fixed once Run starts.
+type LiveConfig struct {
+	StatsInterval time.Duration
+	// SendRate only applies while sending at a fixed rate rather than
+	// with a think time.
+	SendRate int
+	// TotalSendRate can be changed, but not turned on or off.
+	TotalSendRate int
+}
+
+// DefaultConfig returns the configuration used by the command-line tool when
+// no flags are given, minus the required URLs.
+func DefaultConfig() Config {
+	return Config{
+		Concurrency:       100,
+		Rate:              10,
+		URLSelect:         selectRoundRobin,
+		HandshakeTimeout:  45 * time.Second,
+		MaxMessageSize:    1 << 20,
+		AbortMinAttempts:  100,
+		AdaptiveThreshold: 0.05,
+		LifetimeJitter:    0.5,
+		CompressionLevel:  1,
+		ReadTimeout:       10 * time.Second,
+		CloseTimeout:      time.Second,
+		ResponseTimeout:   5 * time.Second,
+		PingInterval:      5 * time.Second,
+		SendRate:          1,
+		ReconnectDelay:    2 * time.Second,
+		ReconnectMaxDelay: 30 * time.Second,
+		StatsInterval:     5 * time.Second,
+	}
+}
+
+// validate checks the settings that don't need any parsing.
+func (c Config) validate() error {
+	if len(c.URLs) == 0 {
+		return errors.New("WebSocket URL (--url) is required")
+	}
+	if c.URLSelect != "" && c.URLSelect != selectRoundRobin && c.URLSelect != selectRandom {
+		return errors.New("URL selection (--url-select) must be round-robin or random")
+	}
+	if c.Concurrency <= 0 {
+		return errors.New("concurrency (--c) must be positive")
+	}
+	if c.Rate <= 0 {
+		return errors.New("rate (--r) must be positive")
+	}
+	if c.MaxMessages < 0 || c.MaxBytes < 0 {
+		return errors.New("message limit (--max-messages) and byte limit (--max-bytes) must not be negative")
+	}
+	if c.AbortErrorRate < 0 || c.AbortErrorRate >= 1 {
+		return errors.New("abort error rate (--abort-on-error-rate) must be at least 0 and below 1")
+	}
+	if c.AbortMinAttempts < 1 {
+		return errors.New("minimum attempts (--abort-min-attempts) must be positive")
+	}
+	if c.AdaptiveRate && (c.AdaptiveThreshold <= 0 || c.AdaptiveThreshold >= 1) {
+		return errors.New("adaptive rate threshold (--adaptive-threshold) must be above 0 and below 1")
+	}
+	if c.GoroutineLimit < 0 {
+		return errors.New("goroutine limit (--goroutine-limit) must not be negative")
+	}
+	if c.RampDownRate < 0 {
+		return errors.New("ramp-down rate (--ramp-down-rate) must not be negative")
+	}
+	if c.HandshakeTimeout < 0 {
+		return errors.New("handshake timeout (--handshake-timeout) must not be negative")
+	}
+	if c.ConnectTimeout < 0 {
+		return errors.New("connect timeout (--connect-timeout) must not be negative")
+	}
+	if c.ConnectTimeout > 0 && c.HandshakeTimeout > 0 && c.ConnectTimeout >= c.HandshakeTimeout {
+		return errors.New("connect timeout (--connect-timeout) must be shorter than --handshake-timeout, which bounds the whole dial")
+	}
+	if c.IdleTimeout < 0 {
+		return errors.New("idle timeout (--idle-timeout) must not be negative")
+	}
+	if c.BackpressureGap < 0 {
+		return errors.New("backpressure gap (--backpressure-gap) must not be negative")
+	}
+	if c.BackpressureGap > 0 && !c.sendsMessages() {
+		return errors.New("backpressure gap (--backpressure-gap) requires --send, --payload-file, --echo, --scenario or --replay")
+	}
+	if c.MaxMessageSize < 0 {
+		return errors.New("max message size (--max-message-size) must not be negative")
+	}
+	if c.Compression && (c.CompressionLevel < -2 || c.CompressionLevel > 9) {
+		return errors.New("compression level (--compression-level) must be between -2 and 9")
+	}
+	if c.Warmup < 0 {
+		return errors.New("warmup (--warmup) must not be negative")
+	}
+	if c.StatsInterval < 0 {
+		return errors.New("stats interval (--stats-interval) must not be negative")
+	}
+	if c.RateWindow < 0 {
+		return errors.New("rate window (--rate-window) must not be negative")
+	}
+	if c.RateWindow > 0 && c.RateWindow < c.StatsInterval {
+		return errors.New("rate window (--rate-window) must be at least --stats-interval")
+	}
+	if c.CSVPath != "" && c.StatsInterval == 0 {
+		return errors.New("CSV stats (--csv) require a positive --stats-interval")
+	}
+	if c.CompressOutput && !compressedExt(c.CSVPath) && !compressedExt(c.ReportPath) && !compressedExt(c.EventsPath) {
+		return errors.New("compressed output (--compress-output) requires a --csv, --report or --events path ending in .gz or .zst")
+	}
+	if c.ReportPath != "" && c.StatsInterval == 0 {
+		return errors.New("the HTML report (--report) requires a positive --stats-interval")
+	}
+	if c.CloseTimeout <= 0 {
+		return errors.New("close timeout (--close-timeout) must be positive")
+	}
+	if c.MaxRuntime < 0 {
+		return errors.New("max runtime (--max-runtime) must not be negative")
+	}
+	if c.MaxRuntime > 0 && c.Duration > 0 && c.MaxRuntime <= c.Duration {
+		return errors.New("max runtime (--max-runtime) must be longer than the test duration (--d), leaving time to shut down")
+	}
+	if c.ReadTimeout < 0 || c.PingInterval < 0 || c.Keepalive < 0 {
+		return errors.New("read timeout (--read-timeout), ping interval (--ping-interval) and keepalive (--keepalive) must not be negative")
+	}
+	if c.HeartbeatInterval < 0 {
+		return errors.New("heartbeat interval (--heartbeat-interval) must not be negative")
+	}
+	if c.HeartbeatMessage != "" && c.HeartbeatInterval == 0 {
+		return errors.New("a heartbeat message (--heartbeat-message) requires a positive --heartbeat-interval")
+	}
+	if c.HeartbeatInterval > 0 && c.HeartbeatMessage == "" {
+		return errors.New("a heartbeat interval (--heartbeat-interval) requires --heartbeat-message")
+	}
+	if c.ConnectionLifetime < 0 {
+		return errors.New("connection lifetime (--connection-lifetime) must not be negative")
+	}
+	if c.LifetimeJitter < 0 || c.LifetimeJitter >= 1 {
+		return errors.New("lifetime jitter (--duration-jitter) must be at least 0 and below 1")
+	}
+	if c.ReconnectAttempts < 0 {
+		return errors.New("reconnect attempts (--reconnect-attempts) must not be negative")
+	}
+	if c.LoginURL == "" && (c.LoginMethod != "" || c.LoginBody != "" || c.LoginTokenPath != "" || c.LoginTokenRegex != "") {
+		return errors.New("--login-method, --login-body and the --login-token flags require --login-url")
+	}
+	if c.LoginURL != "" {
+		if u, err := url.Parse(c.LoginURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("login URL (--login-url) must be an http:// or https:// URL")
+		}
+		if c.LoginTokenPath != "" && c.LoginTokenRegex != "" {
+			return errors.New("--login-token-path and --login-token-regex are mutually exclusive")
+		}
+		if c.Bearer != "" || c.BasicAuth != "" {
+			return errors.New("--login-url can't be combined with --bearer or --basic-auth")
+		}
+	}
+	if c.Send != "" && c.PayloadFile != "" {
+		return errors.New("--send and --payload-file are mutually exclusive")
+	}
+	if c.ThinkTime < 0 || c.ThinkJitter < 0 {
+		return errors.New("think time (--think-time) and think jitter (--think-jitter) must not be negative")
+	}
+	if c.ThinkJitter > 0 && c.ThinkTime == 0 {
+		return errors.New("think jitter (--think-jitter) requires --think-time")
+	}
+	if c.SlowRead < 0 || c.SlowReadJitter < 0 {
+		return errors.New("slow read (--slow-read) and slow read jitter (--slow-read-jitter) must not be negative")
+	}
+	if c.SlowPong < 0 {
+		return errors.New("slow pong (--slow-pong) must not be negative")
+	}
+	if c.SlowReadJitter > 0 && c.SlowRead == 0 {
+		return errors.New("slow read jitter (--slow-read-jitter) requires --slow-read")
+	}
+	if c.Scenario != nil && c.sendingEnabled() {
+		return errors.New("a scenario (--scenario) can't be combined with --send, --payload-file or --echo")
+	}
+	if c.Replay != nil && (c.sendingEnabled() || c.Scenario != nil) {
+		return errors.New("a replay (--replay) can't be combined with --send, --payload-file, --echo or --scenario")
+	}
+	if c.Replay != nil && len(c.Replay.Messages) == 0 {
+		return errors.New("the replay (--replay) holds no messages")
+	}
+	if c.ReplayLoop && c.Replay == nil {
+		return errors.New("--replay-loop requires --replay")
+	}
+	if c.ReplayLoop && c.Replay.Messages[len(c.Replay.Messages)-1].Offset == 0 {
+		return errors.New("--replay-loop requires a replay whose messages span some time, or it would send them as fast as it can")
+	}
+	if c.sendingEnabled() && c.ThinkTime == 0 && c.SendRate <= 0 {
+		return errors.New("send rate (--send-rate) must be positive")
+	}
+	if c.CorrelationField != "" {
+		if c.Echo {
+			return errors.New("--correlate and --echo are mutually exclusive")
+		}
+		if c.Send == "" && c.PayloadFile == "" {
+			return errors.New("request correlation (--correlate) requires --send or --payload-file")
+		}
+		if c.ResponseTimeout <= 0 {
+			return errors.New("response timeout (--response-timeout) must be positive")
+		}
+	}
+	if c.ConnectOnly && (c.sendsMessages() || c.HeartbeatMessage != "") {
+		return errors.New("connect-only mode (--connect-only) can't be combined with --send, --payload-file, --echo, --scenario, --replay or --heartbeat-message")
+	}
+	if c.RetryUntilTarget < 0 {
+		return errors.New("retry until target (--retry-until-target) must not be negative")
+	}
+	if c.RetryUntilTarget > 0 && c.ConnectOnly {
+		return errors.New("--retry-until-target can't be combined with --connect-only, which redials at the rate anyway")
+	}
+	if c.ConnectOnly && (c.Soak || c.ConnectionLifetime > 0) {
+		return errors.New("connect-only mode (--connect-only) can't be combined with --soak or --connection-lifetime")
+	}
+	if c.PoolSize < 0 {
+		return errors.New("pool size (--pool-size) must not be negative")
+	}
+	if c.PoolSize > 0 {
+		switch {
+		case !pollSupported:
+			return errors.New("pool size (--pool-size) is only supported on Linux")
+		case !c.Soak:
+			return errors.New("pool size (--pool-size) requires --soak")
+		case c.IdleTimeout > 0 || c.ConnectionLifetime > 0 || c.HeartbeatInterval > 0 || c.SlowRead > 0 || c.SlowPong > 0:
+			return errors.New("--pool-size can't be combined with --idle-timeout, --connection-lifetime, --heartbeat-interval, --slow-read or --slow-pong")
+		}
+	}
+	if c.Soak && c.sendsMessages() {
+		return errors.New("soak mode (--soak) can't be combined with --send, --payload-file, --echo, --scenario or --replay")
+	}
+	if c.TotalSendRate < 0 {
+		return errors.New("total send rate (--total-send-rate) must not be negative")
+	}
+	if c.TotalSendRate > 0 && !c.sendsMessages() {
+		return errors.New("total send rate (--total-send-rate) requires --send, --payload-file, --echo, --scenario or --replay")
+	}
+	if c.TargetRPS < 0 {
+		return errors.New("target rps (--target-rps) must not be negative")
+	}
+	if c.TargetRPS > 0 {
+		if !c.sendingEnabled() {
+			return errors.New("target rps (--target-rps) requires --send, --payload-file or --echo")
+		}
+		if c.ThinkTime > 0 || c.TotalSendRate > 0 {
+			return errors.New("--target-rps can't be combined with --think-time or --total-send-rate")
+		}
+	}
+	return nil
+}
+
+// pingsEnabled reports whether connections send pings at all.
+func (c Config) pingsEnabled() bool {
+	return c.PingInterval > 0 || c.Keepalive > 0
+}
+
+// pingsShorterThan reports whether some ping interval is shorter than d, so
+// that pongs keep an idle connection's read deadline from expiring.
+func (c Config) pingsShorterThan(d time.Duration) bool {
+	return (c.PingInterval > 0 && c.PingInterval < d) || (c.Keepalive > 0 && c.Keepalive < d)
+}
+
+// pattern returns the load pattern to drive the run with.
+func (c Config) pattern() Pattern {
+	if c.Pattern == nil {
+		return ConstantPattern{Concurrency: c.Concurrency}
+	}
+	return c.Pattern
+}
+
+// sendsMessages reports whether connections write messages at all, from the
+// send loop, a scenario or a replay.
+func (c Config) sendsMessages() bool {
+	return c.sendingEnabled() || c.Scenario != nil || c.Replay != nil
+}
+
+// sendingEnabled reports whether workers write messages in addition to
+// reading them.
+func (c Config) sendingEnabled() bool {
+	return c.Send != "" || c.PayloadFile != "" || c.Echo
+}
+
+// messageType is the frame opcode used for sends.
+func (c Config) messageType() int {
+	if c.Binary {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
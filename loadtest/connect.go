@@ -0,0 +1,36 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// errConnectTimeout marks a dial whose TCP connect took longer than
+// Config.ConnectTimeout.
+var errConnectTimeout = errors.New("connect timeout")
+
+// connectFunc returns the dialer's NetDialContext: it connects from local, if
+// set, and gives up after timeout, if positive, with an error wrapping
+// errConnectTimeout so the failure is told apart from a handshake timeout.
+func connectFunc(local *net.TCPAddr, timeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	if local != nil {
+		d.LocalAddr = local
+	}
+	if timeout <= 0 {
+		return d.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		// With ctx done, or its deadline passed but not yet reported, it was
+		// the handshake deadline that ran out first.
+		var netErr net.Error
+		if err != nil && !stopping(ctx) && errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("%w (--connect-timeout %s): %w", errConnectTimeout, timeout, err)
+		}
+		return conn, err
+	}
+}
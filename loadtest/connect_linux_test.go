@@ -0,0 +1,87 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestConnectTimeoutCounted(t *testing.T) {
+	addr := newUnansweredAddr(t)
+	cfg := testConfig("ws://" + addr + "/")
+	cfg.ConnectTimeout = 200 * time.Millisecond
+	cfg.HandshakeTimeout = 5 * time.Second
+	cfg.NoReconnect = true
+	cfg.Duration = time.Second
+
+	summary, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int64(cfg.Concurrency)
+	if summary.Failed != n || summary.FailureCategories[failConnect] != n {
+		t.Errorf("failed = %d, failureCategories = %v, want %d %s", summary.Failed, summary.FailureCategories, n, failConnect)
+	}
+}
+
+func TestConnectFuncTimeout(t *testing.T) {
+	addr := newUnansweredAddr(t)
+	connect := connectFunc(nil, 100*time.Millisecond)
+
+	start := time.Now()
+	_, err := connect(context.Background(), "tcp", addr)
+	if !errors.Is(err, errConnectTimeout) {
+		t.Fatalf("err = %v, want a connect timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("connect took %s, want about 100ms", elapsed)
+	}
+	if got := classifyDialError(err, nil); got != failConnect {
+		t.Errorf("category = %s, want %s", got, failConnect)
+	}
+
+	// A handshake deadline running out first is the handshake's timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := connect(ctx, "tcp", addr); errors.Is(err, errConnectTimeout) {
+		t.Errorf("err = %v, want it not counted as a connect timeout", err)
+	}
+}
+
+// newUnansweredAddr returns the address of a socket that listens with no
+// room in its accept queue, which is kept filled, so Linux drops every
+// further SYN and connects to it hang, as to a host that is down.
+func newUnansweredAddr(t *testing.T) string {
+	t.Helper()
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	// Fill the queue: once a connect hangs, it is full.
+	for range 8 {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			return addr
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+	t.Fatal("connects to a socket that never accepts kept succeeding")
+	return ""
+}
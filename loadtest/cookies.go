@@ -0,0 +1,162 @@
+package loadtest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpOnlyPrefix marks an HttpOnly cookie in a Netscape cookie file; curl
+// and browser exporters write it in front of the domain.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// newCookieJar builds the jar the dialer sends cookies from. Each of the
+// "name=value" entries is scoped to the host of every target; the cookies in
+// file keep the domain and path they were saved with. Cookies a server sets
+// in its handshake response go into the same jar. The count returned is of
+// the cookies the jar then holds for the targets and the file's URLs, as it
+// drops those it won't send and keeps one of any set twice.
+func newCookieJar(entries []string, file string, targets []*target) (http.CookieJar, int, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var urls []*url.URL
+	for _, t := range targets {
+		u, err := cookieURL(t.dialURL)
+		if err != nil {
+			return nil, 0, err
+		}
+		urls = append(urls, u)
+	}
+	for _, entry := range entries {
+		cookies, err := http.ParseCookie(entry)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid cookie (--cookie) %q: %w", entry, err)
+		}
+		for _, c := range cookies {
+			c.Path = "/"
+		}
+		for _, u := range urls {
+			jar.SetCookies(u, cookies)
+		}
+	}
+
+	if file != "" {
+		saved, err := loadCookieFile(file)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid cookie file (--cookie-file): %w", err)
+		}
+		for _, sc := range saved {
+			jar.SetCookies(sc.url, []*http.Cookie{sc.cookie})
+			urls = append(urls, sc.url)
+		}
+	}
+
+	held := make(map[string]bool)
+	for _, u := range urls {
+		for _, c := range jar.Cookies(u) {
+			held[u.Hostname()+" "+c.Name] = true
+		}
+	}
+	return jar, len(held), nil
+}
+
+// cookieURL is the http(s) URL a cookie jar knows a WebSocket URL by, the
+// same one the dialer asks it about.
+func cookieURL(wsURL string) (*url.URL, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = "http"
+	if strings.HasPrefix(wsURL, "wss:") {
+		u.Scheme = "https"
+	}
+	return u, nil
+}
+
+// savedCookie is a cookie from a cookie file with the URL it belongs to.
+type savedCookie struct {
+	url    *url.URL
+	cookie *http.Cookie
+}
+
+// loadCookieFile reads a Netscape cookie file as written by curl -c and
+// browser exporters: one cookie per line with the tab-separated fields
+// domain, include subdomains, path, secure, expiry and name and value.
+// Blank lines and comments are skipped, as are cookies that have expired.
+func loadCookieFile(path string) ([]savedCookie, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cookies []savedCookie
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = line[len(httpOnlyPrefix):]
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sc, err := parseCookieLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		if sc == nil {
+			continue
+		}
+		sc.cookie.HttpOnly = httpOnly
+		cookies = append(cookies, *sc)
+	}
+	if len(cookies) == 0 {
+		return nil, errors.New(path + " holds no unexpired cookies")
+	}
+	return cookies, nil
+}
+
+// parseCookieLine parses one cookie file line. It returns nil for a cookie
+// that has already expired.
+func parseCookieLine(line string) (*savedCookie, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 7 {
+		return nil, fmt.Errorf("expected 7 tab-separated fields, got %d", len(fields))
+	}
+	domain, subdomains, path, secure, expiry, name, value := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+	host := strings.TrimPrefix(domain, ".")
+	if host == "" || name == "" {
+		return nil, errors.New("missing domain or cookie name")
+	}
+	c := &http.Cookie{Name: name, Value: value, Path: path, Secure: strings.EqualFold(secure, "TRUE")}
+	if strings.EqualFold(subdomains, "TRUE") {
+		c.Domain = host
+	}
+	secs, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry %q", expiry)
+	}
+	if secs != 0 {
+		// Zero marks a session cookie, which never expires here.
+		c.Expires = time.Unix(secs, 0)
+		if c.Expires.Before(time.Now()) {
+			return nil, nil
+		}
+	}
+
+	u := &url.URL{Scheme: "http", Host: host, Path: path}
+	if c.Secure {
+		u.Scheme = "https"
+	}
+	return &savedCookie{url: u, cookie: c}, nil
+}
@@ -0,0 +1,76 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCookiesSentInHandshake(t *testing.T) {
+	var mu sync.Mutex
+	got := make(map[string]string)
+	url := newWSServer(t, func(conn *websocket.Conn, req *http.Request) {
+		mu.Lock()
+		for _, c := range req.Cookies() {
+			got[c.Name] = c.Value
+		}
+		mu.Unlock()
+		conn.ReadMessage()
+	})
+	file := filepath.Join(t.TempDir(), "cookies.txt")
+	lines := "# Netscape HTTP Cookie File\n" +
+		"127.0.0.1\tFALSE\t/\tFALSE\t0\tsession\tabc\n" +
+		"#HttpOnly_127.0.0.1\tFALSE\t/\tFALSE\t0\tcsrf\tdef\n" +
+		// Expired, so not sent.
+		"127.0.0.1\tFALSE\t/\tFALSE\t1\told\tx\n"
+	if err := os.WriteFile(file, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(url)
+	cfg.Concurrency = 1
+	cfg.Cookies = []string{"a=1; b=2"}
+	cfg.CookieFile = file
+	cfg.Duration = 100 * time.Millisecond
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]string{"a": "1", "b": "2", "session": "abc", "csrf": "def"}
+	if len(got) != len(want) {
+		t.Errorf("handshake carried cookies %v, want %v", got, want)
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("cookie %s = %q, want %q", name, got[name], value)
+		}
+	}
+}
+
+func TestNewCookieJarCount(t *testing.T) {
+	targets := []*target{{dialURL: "ws://127.0.0.1:8080/ws"}}
+	file := filepath.Join(t.TempDir(), "cookies.txt")
+	lines := "127.0.0.1\tFALSE\t/\tFALSE\t0\ta\t3\n" +
+		"example.com\tTRUE\t/\tFALSE\t0\tsession\tabc\n"
+	if err := os.WriteFile(file, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a is set three times and held once; session is held for its own
+	// domain.
+	_, n, err := newCookieJar([]string{"a=1", "a=2; b=2"}, file, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("count = %d, want 3", n)
+	}
+}
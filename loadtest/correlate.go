@@ -0,0 +1,101 @@
+package loadtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rpcTracker remembers the requests awaiting a response on one connection,
+// keyed by the correlation id each was sent with. Responses may arrive in
+// any order; whatever isn't answered within Config.ResponseTimeout is
+// expired as a timeout.
+type rpcTracker struct {
+	field  string
+	prefix []byte // `{"<field>":`
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]time.Time
+}
+
+func newRPCTracker(field string) *rpcTracker {
+	name, _ := json.Marshal(field)
+	prefix := append([]byte{'{'}, name...)
+	return &rpcTracker{field: field, prefix: append(prefix, ':'), pending: make(map[uint64]time.Time)}
+}
+
+// isJSONObject reports whether p looks like a JSON object, which is all a
+// correlated request needs to be for the id to be spliced into it.
+func isJSONObject(p []byte) bool {
+	p = bytes.TrimSpace(p)
+	return len(p) >= 2 && p[0] == '{' && p[len(p)-1] == '}'
+}
+
+// next returns the JSON object payload with the next correlation id added as
+// its first member, and records when it was sent.
+func (t *rpcTracker) next(payload []byte) []byte {
+	t.mu.Lock()
+	id := t.nextID
+	t.nextID++
+	t.pending[id] = time.Now()
+	t.mu.Unlock()
+
+	rest := bytes.TrimSpace(payload)[1:]
+	out := make([]byte, 0, len(t.prefix)+21+len(rest))
+	out = append(out, t.prefix...)
+	out = strconv.AppendUint(out, id, 10)
+	if bytes.TrimSpace(rest)[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, rest...)
+}
+
+// match returns the latency of the request p responds to. It reports false
+// if p carries no id of a pending request, as server pushes and responses
+// that already timed out don't.
+func (t *rpcTracker) match(p []byte) (time.Duration, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return 0, false
+	}
+	raw, ok := fields[t.field]
+	if !ok {
+		return 0, false
+	}
+	// Servers that treat ids as opaque may echo them back as strings.
+	id, err := strconv.ParseUint(string(bytes.Trim(raw, `"`)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sent, ok := t.pending[id]
+	if !ok {
+		return 0, false
+	}
+	delete(t.pending, id)
+	return time.Since(sent), true
+}
+
+// expire forgets the requests sent more than timeout ago and returns how many
+// there were.
+func (t *rpcTracker) expire(timeout time.Duration) int {
+	deadline := time.Now().Add(-timeout)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for id, sent := range t.pending {
+		if sent.Before(deadline) {
+			delete(t.pending, id)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,76 @@
+package loadtest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+)
+
+const csvTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// csvStats appends one row per stats interval to a CSV file.
+type csvStats struct {
+	f         *outputFile
+	w         *csv.Writer
+	withSends bool
+}
+
+// openCSVStats creates path, compressed as createOutput does, and writes the
+// header row. The messagesSent column is only included when withSends is
+// set.
+func openCSVStats(path string, withSends, compress bool) (*csvStats, error) {
+	f, err := createOutput(path, compress)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &csvStats{f: f, w: csv.NewWriter(f), withSends: withSends}
+	header := []string{"timestamp", "active", "succeeded", "failed", "bytesRead"}
+	if withSends {
+		header = append(header, "messagesSent")
+	}
+	if err := c.writeRecord(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Write appends snap as a row and flushes it to disk.
+func (c *csvStats) Write(snap statsSnapshot) error {
+	record := []string{
+		snap.Time.Format(csvTimeFormat),
+		strconv.FormatInt(snap.Active, 10),
+		strconv.FormatInt(snap.Succeeded, 10),
+		strconv.FormatInt(snap.Failed, 10),
+		strconv.FormatInt(snap.BytesRead, 10),
+	}
+	if c.withSends {
+		record = append(record, strconv.FormatInt(snap.MessagesSent, 10))
+	}
+	return c.writeRecord(record)
+}
+
+func (c *csvStats) writeRecord(record []string) error {
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	c.w.Flush()
+	err := c.w.Error()
+	if err == nil {
+		err = c.f.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", c.f.Name(), err)
+	}
+	return nil
+}
+
+func (c *csvStats) Close() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
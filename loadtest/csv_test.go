@@ -0,0 +1,65 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCSVStats(t *testing.T) {
+	url := newEchoServer(t)
+	tests := []struct {
+		name    string
+		send    string
+		columns int
+	}{
+		{name: "read only", columns: 5},
+		{name: "sending", send: "hi", columns: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stats.csv")
+			cfg := testConfig(url)
+			cfg.CSVPath = path
+			cfg.StatsInterval = 20 * time.Millisecond
+			cfg.Duration = 200 * time.Millisecond
+			cfg.Send = tt.send
+			if _, err := Run(context.Background(), cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			rows, err := csv.NewReader(f).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) < 3 {
+				t.Fatalf("got %d rows, want a header and a row per interval", len(rows))
+			}
+			if rows[0][0] != "timestamp" || len(rows[0]) != tt.columns {
+				t.Errorf("header = %q, want %d columns starting with timestamp", rows[0], tt.columns)
+			}
+			var prev time.Time
+			for i, row := range rows[1:] {
+				if len(row) != tt.columns {
+					t.Fatalf("row %d = %q, want %d columns", i+1, row, tt.columns)
+				}
+				ts, err := time.Parse(csvTimeFormat, row[0])
+				if err != nil {
+					t.Fatalf("row %d: %v", i+1, err)
+				}
+				if ts.Before(prev) {
+					t.Errorf("row %d: timestamp %s before %s", i+1, ts, prev)
+				}
+				prev = ts
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// dashboardInterval is how often the dashboard is redrawn.
+	dashboardInterval = 250 * time.Millisecond
+	// sparkWidth is how many redraws each sparkline covers.
+	sparkWidth = 40
+	// dashboardEvents is how many recent log records are shown.
+	dashboardEvents = 8
+	// maxEventWidth truncates long log records in the event panel.
+	maxEventWidth = 160
+)
+
+// ANSI SGR sequences for the dashboard's colors.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiFaint  = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+)
+
+// dashboard is a live terminal view of a run, redrawn in place with ANSI
+// escape codes. While it is shown, log records are collected into its event
+// panel instead of being written out, so they don't scroll it away.
+type dashboard struct {
+	w      io.Writer
+	color  bool
+	active atomic.Bool
+
+	mu     sync.Mutex
+	events []dashEvent
+
+	// The fields below are only touched by the drawing goroutine.
+	prev          statsSnapshot
+	prevHandshake LatencyStats
+	prevEcho      LatencyStats
+	opened        sparkline
+	read          sparkline
+	sent          sparkline
+	handshake     sparkline
+	echo          sparkline
+}
+
+// dashEvent is a log record in the event panel, kept as plain text so that
+// truncating it can't cut a color sequence in half.
+type dashEvent struct {
+	level slog.Level
+	text  string
+}
+
+func (d *dashboard) addEvent(level slog.Level, line string) {
+	if len(line) > maxEventWidth {
+		line = line[:maxEventWidth-3] + "..."
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, dashEvent{level, line})
+	if len(d.events) > dashboardEvents {
+		d.events = slices.Delete(d.events, 0, len(d.events)-dashboardEvents)
+	}
+}
+
+func (d *dashboard) recentEvents() []dashEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.events)
+}
+
+// paint wraps s in the color code if the dashboard is colored and returns it
+// unchanged otherwise.
+func (d *dashboard) paint(code, s string) string {
+	if !d.color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// levelColor is the color of records at level in the event panel.
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return ansiRed
+	case level >= slog.LevelWarn:
+		return ansiYellow
+	case level < slog.LevelInfo:
+		return ansiFaint
+	}
+	return ""
+}
+
+// runDashboard redraws the dashboard until done is closed, then draws a last
+// frame and hands the terminal back to the logger. ctx being done marks the
+// run as stopping while connections close.
+func (r *runner) runDashboard(ctx context.Context, done <-chan struct{}, start time.Time) {
+	d := r.dash
+	d.prev = r.takeSnapshot()
+
+	// Clear the screen once, hide the cursor, and show it again on return.
+	fmt.Fprint(d.w, "\x1b[2J\x1b[H\x1b[?25l")
+	defer fmt.Fprint(d.w, "\x1b[?25h")
+	d.active.Store(true)
+	defer d.active.Store(false)
+
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			state := "running"
+			if ctx.Err() != nil {
+				state = "stopping"
+			}
+			r.drawDashboard(state, time.Since(start))
+		case <-done:
+			r.drawDashboard("finished", time.Since(start))
+			return
+		}
+	}
+}
+
+func (r *runner) drawDashboard(state string, elapsed time.Duration) {
+	d := r.dash
+	cfg := r.cfg
+
+	snap := r.takeSnapshot()
+	rates := snap.ratesSince(d.prev)
+	d.prev = snap
+	handshake := r.handshakes.Snapshot()
+	d.handshake.push(float64(intervalAvg(d.prevHandshake, handshake)))
+	d.prevHandshake = handshake
+	d.opened.push(rates.ConnsPerSec)
+	d.read.push(rates.BytesReadPerSec)
+	d.sent.push(rates.MessagesPerSec)
+
+	var b bytes.Buffer
+	// Each line clears what an earlier, longer frame left behind.
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\x1b[K\n")
+	}
+
+	b.WriteString("\x1b[H")
+	elapsed = elapsed.Round(time.Second)
+	title := d.paint(ansiBold, "go-socket-storm")
+	if cfg.Duration > 0 {
+		line("%s  %s  %s of %s", title, state, elapsed, cfg.Duration)
+	} else {
+		line("%s  %s  %s", title, state, elapsed)
+	}
+	urls := make([]string, len(r.targets))
+	for i, t := range r.targets {
+		urls[i] = t.url
+	}
+	line("%-12s %s", "Targets", strings.Join(urls, ", "))
+	line("")
+	line("%-12s active %d of %d  peak %d  attempts %d  succeeded %d  failed %d  reconnects %d",
+		"Connections", snap.Active, cfg.Concurrency, r.peakActive.Load(), snap.Attempts, snap.Succeeded, snap.Failed, snap.Reconnects)
+	line("%-12s %-12s %s", "Opened/s", fmt.Sprintf("%.1f", rates.ConnsPerSec), &d.opened)
+	line("%-12s %-12s %s", "Read/s", formatBytes(rates.BytesReadPerSec), &d.read)
+	if cfg.sendsMessages() {
+		line("%-12s %-12s %s", "Sent/s", fmt.Sprintf("%.1f msg", rates.MessagesPerSec), &d.sent)
+	}
+	line("%-12s %-36s %s", "Handshake", formatPercentiles(handshake), &d.handshake)
+	if cfg.Echo {
+		echo := r.echoLatency.Snapshot()
+		d.echo.push(float64(intervalAvg(d.prevEcho, echo)))
+		d.prevEcho = echo
+		line("%-12s %-36s %s", "Echo RTT", formatPercentiles(echo), &d.echo)
+	}
+	if cfg.CorrelationField != "" {
+		line("%-12s %-36s timeouts %d", "Response", formatPercentiles(r.responseLatency.Snapshot()), snap.ResponseTimeouts)
+	}
+	if cfg.pingsEnabled() {
+		line("%-12s pings %d  pongs %d", "Keepalive", snap.PingsSent, snap.PongsReceived)
+	}
+	if snap.PingsReceived > 0 || cfg.SlowPong > 0 {
+		line("%-12s received %d  %.1f/s  pongs %d  interval %s", "Server ping",
+			snap.PingsReceived, rates.ServerPingsPerSec, snap.PongsSent, formatPercentiles(r.serverPingInterval.Snapshot()))
+	}
+	if cfg.HeartbeatInterval > 0 {
+		line("%-12s sent %d", "Heartbeats", snap.Heartbeats)
+	}
+	if cfg.Replay != nil {
+		line("%-12s completed %d", "Replays", snap.Replays)
+	}
+	if cfg.ConnectionLifetime > 0 {
+		line("%-12s closed by lifetime %d", "Churn", snap.Churned)
+	}
+
+	failures := "none"
+	if counts := r.failures.Snapshot(); len(counts) > 0 {
+		parts := make([]string, 0, len(counts))
+		for _, k := range sortedKeys(counts) {
+			parts = append(parts, fmt.Sprintf("%s=%d", k, counts[k]))
+		}
+		failures = d.paint(ansiRed, strings.Join(parts, "  "))
+	}
+	line("%-12s %s", "Failures", failures)
+
+	line("")
+	line("Recent events")
+	for _, e := range d.recentEvents() {
+		if code := levelColor(e.level); code != "" {
+			line("  %s", d.paint(code, e.text))
+		} else {
+			line("  %s", e.text)
+		}
+	}
+	b.WriteString("\x1b[J")
+	d.w.Write(b.Bytes())
+}
+
+// intervalAvg returns the mean of the samples recorded between two snapshots
+// of the same histogram, or zero if there were none.
+func intervalAvg(prev, cur LatencyStats) time.Duration {
+	n := cur.Count - prev.Count
+	if n == 0 {
+		return 0
+	}
+	total := cur.Avg*time.Duration(cur.Count) - prev.Avg*time.Duration(prev.Count)
+	return total / time.Duration(n)
+}
+
+func formatPercentiles(s LatencyStats) string {
+	if s.Count == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("p50 %s  p95 %s  p99 %s",
+		roundLatency(s.P50), roundLatency(s.P95), roundLatency(s.P99))
+}
+
+func formatBytes(v float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline keeps the last sparkWidth values of a series and renders them
+// scaled to the largest.
+type sparkline struct {
+	values []float64
+}
+
+func (s *sparkline) push(v float64) {
+	s.values = append(s.values, v)
+	if len(s.values) > sparkWidth {
+		s.values = slices.Delete(s.values, 0, len(s.values)-sparkWidth)
+	}
+}
+
+func (s *sparkline) String() string {
+	peak := 0.0
+	for _, v := range s.values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for _, v := range s.values {
+		i := 0
+		if peak > 0 {
+			i = int(v/peak*float64(len(sparkBlocks)-1) + 0.5)
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
+
+// dashboardHandler sends log records to the dashboard's event panel while it
+// is shown and to next otherwise.
+type dashboardHandler struct {
+	next  slog.Handler
+	dash  *dashboard
+	attrs []slog.Attr
+}
+
+func (h *dashboardHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *dashboardHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if !h.dash.active.Load() {
+		return h.next.Handle(ctx, rec)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", rec.Time.Format(time.TimeOnly), rec.Level, rec.Message)
+	writeAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	rec.Attrs(writeAttr)
+	h.dash.addEvent(rec.Level, b.String())
+	return nil
+}
+
+func (h *dashboardHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &dashboardHandler{next: h.next.WithAttrs(attrs), dash: h.dash, attrs: append(slices.Clip(h.attrs), attrs...)}
+}
+
+func (h *dashboardHandler) WithGroup(name string) slog.Handler {
+	return &dashboardHandler{next: h.next.WithGroup(name), dash: h.dash, attrs: h.attrs}
+}
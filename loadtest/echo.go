@@ -0,0 +1,84 @@
+package loadtest
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// echoPrefix marks payloads sent in -echo mode. The full payload is
+// "sockstorm:<seq>:<unix-nanos>", optionally followed by ":" and the -send
+// text or -payload-file contents. The sequence id is what correlates an echo with its send, so echoes
+// that arrive out of order are still matched to the right timestamp; the wall
+// clock value is only there for server-side debugging.
+var echoPrefix = []byte("sockstorm:")
+
+// maxPendingEchoes bounds how many unanswered sends a connection remembers.
+// When it is exceeded the oldest send is forgotten and its echo, if it ever
+// arrives, is ignored.
+const maxPendingEchoes = 1024
+
+// echoTracker remembers when each sequence id was sent on one connection.
+type echoTracker struct {
+	mu      sync.Mutex
+	nextSeq uint64
+	pending map[uint64]time.Time
+}
+
+func newEchoTracker() *echoTracker {
+	return &echoTracker{pending: make(map[uint64]time.Time)}
+}
+
+// next returns the payload for the next send and records its send time.
+func (t *echoTracker) next(suffix []byte) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seq := t.nextSeq
+	t.nextSeq++
+
+	now := time.Now()
+	t.pending[seq] = now
+	if seq >= maxPendingEchoes {
+		delete(t.pending, seq-maxPendingEchoes)
+	}
+
+	payload := make([]byte, 0, len(echoPrefix)+40+len(suffix))
+	payload = append(payload, echoPrefix...)
+	payload = strconv.AppendUint(payload, seq, 10)
+	payload = append(payload, ':')
+	payload = strconv.AppendInt(payload, now.UnixNano(), 10)
+	if len(suffix) > 0 {
+		payload = append(payload, ':')
+		payload = append(payload, suffix...)
+	}
+	return payload
+}
+
+// match returns the round-trip time for an echoed payload. It reports false
+// if p is not an echo of a pending send.
+func (t *echoTracker) match(p []byte) (time.Duration, bool) {
+	if !bytes.HasPrefix(p, echoPrefix) {
+		return 0, false
+	}
+	rest := p[len(echoPrefix):]
+	end := bytes.IndexByte(rest, ':')
+	if end < 0 {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(string(rest[:end]), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sent, ok := t.pending[seq]
+	if !ok {
+		return 0, false
+	}
+	delete(t.pending, seq)
+	return time.Since(sent), true
+}
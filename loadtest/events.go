@@ -0,0 +1,153 @@
+package loadtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types written to Config.EventsPath.
+const (
+	eventOpened          = "connection_opened"
+	eventFailed          = "connection_failed"
+	eventClosed          = "connection_closed"
+	eventMessage         = "message_received"
+	eventAssertionFailed = "assertion_failed"
+)
+
+// eventBacklog is how many events may wait to be written before new ones
+// are dropped.
+const eventBacklog = 8192
+
+// event is one line of the event stream. Worker is the worker id (ConnID in
+// payload templates) and Connection the worker's count of opened
+// connections, so together they name one connection.
+type event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Worker     uint64    `json:"worker"`
+	Connection int       `json:"connection,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Local      string    `json:"local,omitempty"`
+	// HandshakeMs is set on connection_opened.
+	HandshakeMs float64 `json:"handshakeMs,omitempty"`
+	// Category, Status and Error describe a connection_failed.
+	Category string `json:"category,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Reason says why a connection_closed: shutdown, dropped or expired.
+	// CloseCode is the close frame's code if the server sent one.
+	Reason    string `json:"reason,omitempty"`
+	CloseCode int    `json:"closeCode,omitempty"`
+	// LifetimeMs and the totals below describe the connection a
+	// connection_closed ends, from its handshake on.
+	LifetimeMs       float64 `json:"lifetimeMs,omitempty"`
+	BytesRead        int64   `json:"bytesRead,omitempty"`
+	BytesWritten     int64   `json:"bytesWritten,omitempty"`
+	MessagesSent     int64   `json:"messagesSent,omitempty"`
+	MessagesReceived int64   `json:"messagesReceived,omitempty"`
+	// Bytes and Message describe a message_received or the message an
+	// assertion_failed was about.
+	Bytes   int    `json:"bytes,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// eventLog writes events as JSON lines from a goroutine of its own. Workers
+// hand events over without blocking; when the writer falls behind by more
+// than eventBacklog, further events are dropped and counted instead.
+type eventLog struct {
+	events  chan event
+	w       *bufio.Writer
+	f       *outputFile // nil for stdout
+	done    chan struct{}
+	err     error // the first write error, owned by the writer
+	dropped atomic.Int64
+	// closed is set by Close; mu keeps emit from sending on the closed
+	// channel.
+	mu     sync.RWMutex
+	closed bool
+}
+
+// openEventLog creates path, compressed as createOutput does, or uses stdout
+// for "-", and starts the writer.
+func openEventLog(path string, compress bool) (*eventLog, error) {
+	var out io.Writer = os.Stdout
+	var f *outputFile
+	if path != "-" {
+		var err error
+		if f, err = createOutput(path, compress); err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	l := &eventLog{
+		events: make(chan event, eventBacklog),
+		w:      bufio.NewWriter(out),
+		f:      f,
+		done:   make(chan struct{}),
+	}
+	go l.write()
+	return l, nil
+}
+
+// emit queues e, stamping it with the current time, or counts it as dropped
+// if the backlog is full. It does nothing once the log is closed.
+func (l *eventLog) emit(e event) {
+	e.Time = time.Now()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.events <- e:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+func (l *eventLog) write() {
+	defer close(l.done)
+	enc := json.NewEncoder(l.w)
+	enc.SetEscapeHTML(false)
+	for e := range l.events {
+		if l.err != nil {
+			continue
+		}
+		l.err = enc.Encode(e)
+		// Flushing whenever the backlog is drained keeps a tailing reader
+		// current without a write per event under load.
+		if l.err == nil && len(l.events) == 0 {
+			l.err = l.w.Flush()
+			if l.err == nil && l.f != nil {
+				l.err = l.f.Flush()
+			}
+		}
+	}
+}
+
+// Close writes the queued events and closes the file. Events emitted after
+// it are discarded.
+func (l *eventLog) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	close(l.events)
+	l.mu.Unlock()
+	<-l.done
+
+	err := l.err
+	if err == nil {
+		err = l.w.Flush()
+	}
+	if l.f != nil {
+		if cerr := l.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package loadtest
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEventsClosedWhenMaxRuntimeGivesUp(t *testing.T) {
+	// The server never reads, so it never answers the close frames and the
+	// run gives up on its connections.
+	url := newWSServer(t, func(conn *websocket.Conn, req *http.Request) {
+		<-req.Context().Done()
+	})
+	path := filepath.Join(t.TempDir(), "events.jsonl.gz")
+	cfg := testConfig(url)
+	cfg.EventsPath = path
+	cfg.CompressOutput = true
+	cfg.Duration = 100 * time.Millisecond
+	cfg.MaxRuntime = 300 * time.Millisecond
+	cfg.CloseTimeout = 2 * time.Second
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := 0
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("bad event %q: %v", scanner.Text(), err)
+		}
+		if e.Type == eventOpened {
+			opened++
+		}
+	}
+	// A stream that wasn't closed ends without its gzip trailer.
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("reading the events: %v", err)
+	}
+	if opened != cfg.Concurrency {
+		t.Errorf("%d connection_opened events, want %d", opened, cfg.Concurrency)
+	}
+}
@@ -0,0 +1,106 @@
+package loadtest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+)
+
+// Failure categories.
+const (
+	failDNS     = "dns"
+	failRefused = "refused"
+	failTLS     = "tls"
+	failHTTP    = "http"
+	failTimeout = "timeout"
+	failConnect = "connectTimeout"
+	failDropped = "dropped"
+	failPorts   = "portExhaustion"
+	failOther   = "other"
+)
+
+// recordFailure counts a failed dial or, with reconnection disabled, a
+// dropped connection. The first port exhaustion failure also logs how to
+// make more source ports available.
+func (r *runner) recordFailure(t *target, category string) {
+	atomic.AddInt64(&r.stats.failed, 1)
+	atomic.AddInt64(&t.failed, 1)
+	if r.failures.Add(category) == 1 && category == failPorts {
+		r.log.Warn("Ran out of local ports for new connections; widen net.ipv4.ip_local_port_range, "+
+			"enable net.ipv4.tcp_tw_reuse, or spread connections over more source IPs with --local-addr",
+			"concurrency", r.cfg.Concurrency)
+	}
+}
+
+// maxLoggedStatusBodies is how many failed handshakes per status code are
+// logged with their response body at debug level.
+const maxLoggedStatusBodies = 3
+
+// maxLoggedBody bounds how much of a handshake response body is logged.
+const maxLoggedBody = 200
+
+// recordStatus tallies the status code of a rejected handshake and, in
+// debug mode, logs the first few responses for each code.
+func (r *runner) recordStatus(resp *http.Response) {
+	n := r.statusCodes.Add(strconv.Itoa(resp.StatusCode))
+	if n > maxLoggedStatusBodies || !r.log.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxLoggedBody+1))
+	text := string(body)
+	if len(body) > maxLoggedBody {
+		text = string(body[:maxLoggedBody]) + "..."
+	}
+	r.log.Debug("Handshake rejected", "status", resp.StatusCode, "body", text)
+}
+
+// statusText returns the reason phrase for a tallied status code.
+func statusText(code string) string {
+	n, _ := strconv.Atoi(code)
+	return http.StatusText(n)
+}
+
+// classifyDialError sorts a failed dial into a failure category. resp is the
+// handshake response Dial returned alongside err, if any.
+func classifyDialError(err error, resp *http.Response) string {
+	if resp != nil && errors.Is(err, websocket.ErrBadHandshake) {
+		return failHTTP
+	}
+
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, errConnectTimeout):
+		return failConnect
+	case errors.As(err, &dnsErr):
+		return failDNS
+	case errors.Is(err, syscall.EADDRNOTAVAIL), errors.Is(err, syscall.EADDRINUSE):
+		// The kernel had no free ephemeral port for this source address.
+		return failPorts
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return failRefused
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return failTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return failTimeout
+	}
+	return failOther
+}
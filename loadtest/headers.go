@@ -0,0 +1,137 @@
+package loadtest
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// reservedHeaders are managed by the dialer; gorilla/websocket refuses to
+// dial if any of them is supplied.
+var reservedHeaders = map[string]bool{
+	"Upgrade":                  true,
+	"Connection":               true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+}
+
+// parseHeaders turns "Key: Value" entries into an http.Header.
+func parseHeaders(entries []string) (http.Header, error) {
+	header := http.Header{}
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q: expected \"Key: Value\"", entry)
+		}
+		key = strings.TrimSpace(key)
+		if !validHeaderKey(key) {
+			return nil, fmt.Errorf("invalid header %q: bad header name %q", entry, key)
+		}
+		if reservedHeaders[http.CanonicalHeaderKey(key)] {
+			return nil, fmt.Errorf("invalid header %q: %s is set by the WebSocket handshake", entry, key)
+		}
+		header.Add(key, strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// headerTokenChars are the non-alphanumeric characters allowed in an
+// RFC 7230 header name.
+const headerTokenChars = "!#$%&'*+-.^_`|~"
+
+// validHeaderKey reports whether key is a non-empty RFC 7230 token.
+func validHeaderKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		alnum := (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !alnum && !strings.ContainsRune(headerTokenChars, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// originNone is the Config.Origin that sends no Origin header.
+const originNone = "none"
+
+// validOrigin reports whether s is an origin, scheme://host[:port] with
+// nothing after it.
+func validOrigin(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != "" && u.User == nil &&
+		(u.Path == "" || u.Path == "/") && u.RawQuery == "" && u.Fragment == ""
+}
+
+// urlOrigin is the origin of a page served from the host of a WebSocket
+// URL, which is what a browser connecting to its own server sends.
+func urlOrigin(wsURL string) (string, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return "", err
+	}
+	scheme := "http"
+	if u.Scheme == "wss" {
+		scheme = "https"
+	}
+	return scheme + "://" + u.Host, nil
+}
+
+// applyOrigin gives every target its handshake header: header itself, or a
+// copy with the Origin Config.Origin asks for.
+func applyOrigin(header http.Header, origin string, targets []*target) error {
+	if header.Get("Origin") != "" && origin != "" {
+		return errors.New("-origin conflicts with -H Origin")
+	}
+	if origin != "" && origin != originNone && !validOrigin(origin) {
+		return fmt.Errorf("invalid -origin %q: expected scheme://host[:port]", origin)
+	}
+	if origin != "" && origin != originNone {
+		header.Set("Origin", origin)
+	}
+
+	for _, t := range targets {
+		t.header = header
+		if origin != "" || header.Get("Origin") != "" {
+			continue
+		}
+		derived, err := urlOrigin(t.dialURL)
+		if err != nil {
+			return err
+		}
+		t.header = header.Clone()
+		t.header.Set("Origin", derived)
+	}
+	return nil
+}
+
+// applyAuth sets the Authorization header from -bearer or -basic-auth. At
+// most one source of Authorization may be given, including -H.
+func applyAuth(header http.Header, bearer, basicAuth string) error {
+	if bearer != "" && basicAuth != "" {
+		return errors.New("-bearer and -basic-auth are mutually exclusive")
+	}
+	if bearer == "" && basicAuth == "" {
+		return nil
+	}
+	if header.Get("Authorization") != "" {
+		return errors.New("-bearer/-basic-auth conflicts with -H Authorization")
+	}
+
+	if bearer != "" {
+		header.Set("Authorization", "Bearer "+bearer)
+		return nil
+	}
+
+	if !strings.Contains(basicAuth, ":") {
+		// The value is a credential, so it stays out of the error.
+		return errors.New("invalid -basic-auth: expected user:pass")
+	}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(basicAuth)))
+	return nil
+}
@@ -0,0 +1,197 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    http.Header
+		wantErr bool
+	}{
+		{name: "none", want: http.Header{}},
+		{
+			name:    "trimmed and canonical",
+			entries: []string{"x-tenant:  acme ", "X-Trace: 1", "x-trace:2"},
+			want:    http.Header{"X-Tenant": {"acme"}, "X-Trace": {"1", "2"}},
+		},
+		{
+			name:    "colon in value",
+			entries: []string{"X-Url: http://example.com:8080"},
+			want:    http.Header{"X-Url": {"http://example.com:8080"}},
+		},
+		{name: "empty value", entries: []string{"X-Empty:"}, want: http.Header{"X-Empty": {""}}},
+		{name: "no colon", entries: []string{"X-Tenant acme"}, wantErr: true},
+		{name: "empty name", entries: []string{": acme"}, wantErr: true},
+		{name: "space in name", entries: []string{"X Tenant: acme"}, wantErr: true},
+		{name: "reserved", entries: []string{"sec-websocket-key: abc"}, wantErr: true},
+		{name: "upgrade", entries: []string{"Upgrade: h2c"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaders(tt.entries)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseHeaders(%q) = %v, want an error", tt.entries, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeaders(%q) = %v, want %v", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyAuth(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    http.Header
+		bearer    string
+		basicAuth string
+		want      string
+		wantErr   bool
+	}{
+		{name: "none", header: http.Header{}},
+		{name: "bearer", header: http.Header{}, bearer: "tok", want: "Bearer tok"},
+		{name: "basic", header: http.Header{}, basicAuth: "user:pa:ss", want: "Basic dXNlcjpwYTpzcw=="},
+		{name: "basic without colon", header: http.Header{}, basicAuth: "secret", wantErr: true},
+		{name: "both", header: http.Header{}, bearer: "tok", basicAuth: "user:pass", wantErr: true},
+		{name: "conflicts with -H", header: http.Header{"Authorization": {"Token x"}}, bearer: "tok", wantErr: true},
+		{name: "-H alone", header: http.Header{"Authorization": {"Token x"}}, want: "Token x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := applyAuth(tt.header, tt.bearer, tt.basicAuth)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("applyAuth succeeded, want an error")
+				}
+				if tt.basicAuth != "" && strings.Contains(err.Error(), tt.basicAuth) {
+					t.Errorf("error %q shows the credentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeadersSentInHandshake(t *testing.T) {
+	got := make(chan http.Header, 2)
+	url := newWSServer(t, func(conn *websocket.Conn, req *http.Request) {
+		got <- req.Header
+		conn.ReadMessage()
+	})
+	cfg := testConfig(url)
+	cfg.Concurrency = 1
+	cfg.Headers = []string{"X-Api-Key: secret", "X-Tenant: acme", "X-Tenant: beta"}
+	cfg.Bearer = "tok"
+	cfg.Duration = 100 * time.Millisecond
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	header := <-got
+	for key, want := range map[string]string{
+		"X-Api-Key":     "secret",
+		"X-Tenant":      "acme, beta",
+		"Authorization": "Bearer tok",
+	} {
+		if v := strings.Join(header.Values(key), ", "); v != want {
+			t.Errorf("%s = %q, want %q", key, v, want)
+		}
+	}
+}
+
+func TestOriginHeader(t *testing.T) {
+	origins := make(chan []string, 16)
+	url := newWSServer(t, func(conn *websocket.Conn, req *http.Request) {
+		origins <- req.Header.Values("Origin")
+		conn.ReadMessage()
+	})
+	host := strings.TrimPrefix(url, "ws://")
+
+	tests := []struct {
+		name    string
+		origin  string
+		headers []string
+		want    []string
+	}{
+		{name: "derived from the URL", want: []string{"http://" + host}},
+		{name: "flag", origin: "https://app.example.com", want: []string{"https://app.example.com"}},
+		{name: "none", origin: originNone},
+		{name: "-H", headers: []string{"Origin: https://other.example.com"}, want: []string{"https://other.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(url)
+			cfg.Concurrency = 1
+			cfg.Origin = tt.origin
+			cfg.Headers = tt.headers
+			cfg.Duration = 100 * time.Millisecond
+			if _, err := Run(context.Background(), cfg); err != nil {
+				t.Fatal(err)
+			}
+			if got := <-origins; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Origin = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		header  http.Header
+		dialURL string
+		want    string
+		wantErr bool
+	}{
+		{name: "wss derives https", dialURL: "wss://api.example.com:8443/ws", want: "https://api.example.com:8443"},
+		{name: "trailing slash", origin: "https://app.example.com/", dialURL: "ws://a/", want: "https://app.example.com/"},
+		{name: "path", origin: "https://app.example.com/page", dialURL: "ws://a/", wantErr: true},
+		{name: "no scheme", origin: "app.example.com", dialURL: "ws://a/", wantErr: true},
+		{name: "with -H Origin", origin: "https://a", header: http.Header{"Origin": {"https://b"}}, dialURL: "ws://a/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			targets := []*target{{dialURL: tt.dialURL}}
+			err := applyOrigin(header, tt.origin, targets)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("applyOrigin succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := targets[0].header.Get("Origin"); got != tt.want {
+				t.Errorf("Origin = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package loadtest
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// testUpgrader accepts every origin, as the test servers are only reached
+// from the tests themselves.
+var testUpgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// newEchoServer starts a WebSocket server that echoes every message back and
+// returns its ws:// URL. It is shut down when the test ends.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	return newWSServer(t, func(conn *websocket.Conn, _ *http.Request) {
+		for {
+			messageType, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, p); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// newWSServer starts a WebSocket server that upgrades every request and hands
+// the connection to handle, and returns its ws:// URL.
+func newWSServer(t *testing.T, handle func(conn *websocket.Conn, req *http.Request)) string {
+	t.Helper()
+	return newUpgraderServer(t, &testUpgrader, handle)
+}
+
+// newUpgraderServer is newWSServer with upgrader in place of testUpgrader.
+func newUpgraderServer(t *testing.T, upgrader *websocket.Upgrader, handle func(conn *websocket.Conn, req *http.Request)) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn, req)
+	}))
+	t.Cleanup(srv.Close)
+	return wsURL(srv.URL)
+}
+
+// wsURL turns the http(s):// URL of an httptest server into a ws(s):// one.
+func wsURL(httpURL string) string {
+	return "ws" + strings.TrimPrefix(httpURL, "http")
+}
+
+// testConfig returns a configuration for a short run against url that logs
+// nothing.
+func testConfig(url string) Config {
+	cfg := DefaultConfig()
+	cfg.URLs = []string{url}
+	cfg.Concurrency = 2
+	cfg.Rate = 100
+	cfg.Quiet = true
+	cfg.Logger = slog.New(slog.DiscardHandler)
+	return cfg
+}
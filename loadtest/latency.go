@@ -0,0 +1,267 @@
+package loadtest
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Each power of two is split into histSubBuckets linear buckets, which keeps
+// the relative error of a reported percentile under ~6% while the memory
+// footprint stays fixed regardless of how many samples are recorded.
+const (
+	histSubBits    = 4
+	histSubBuckets = 1 << histSubBits
+	histBuckets    = (64 - histSubBits + 1) * histSubBuckets
+)
+
+// latencyRangeBounds are the upper bounds of the coarse ranges a summary
+// shows the shape of a distribution with; the last range is open-ended.
+var latencyRangeBounds = [...]time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second}
+
+// latencyRangeNames labels the coarse ranges in logs and JSON.
+var latencyRangeNames = [latencyRanges]string{"0-1ms", "1-10ms", "10-100ms", "100ms-1s", "1s+"}
+
+const latencyRanges = len(latencyRangeBounds) + 1
+
+// latencyHistogram is a fixed-size log-linear histogram of durations. It is
+// safe for concurrent use.
+type latencyHistogram struct {
+	mu     sync.Mutex
+	counts [histBuckets]uint64
+	count  uint64
+	ranges [latencyRanges]uint64
+	sum    time.Duration
+	sumSq  float64 // of nanoseconds, for the standard deviation
+	min    time.Duration
+	max    time.Duration
+}
+
+// histShards is how many independently locked histograms a LatencyRecorder
+// spreads its samples over.
+const histShards = 16
+
+// LatencyRecorder collects durations into a fixed-size histogram and reports
+// their percentiles. It is safe for concurrent use and the zero value is
+// ready to use. Samples are spread over several independently locked shards
+// so that thousands of goroutines recording at once rarely wait on the same
+// lock; Snapshot merges them.
+type LatencyRecorder struct {
+	shards [histShards]latencyHistogram
+}
+
+// LatencyStats is a point-in-time summary of recorded latencies.
+type LatencyStats struct {
+	Count uint64
+	Min   time.Duration
+	Avg   time.Duration
+	Max   time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	// StdDev is the standard deviation of the samples and Ranges how many
+	// fell into each of the coarse ranges bounded by latencyRangeBounds.
+	StdDev time.Duration
+	Ranges [latencyRanges]uint64
+}
+
+func histBucket(v uint64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histSubBits - 1
+	return (shift+1)*histSubBuckets + int(v>>shift) - histSubBuckets
+}
+
+// histBucketMid returns the midpoint of the values that map to bucket idx.
+func histBucketMid(idx int) uint64 {
+	if idx < histSubBuckets {
+		return uint64(idx)
+	}
+	shift := idx/histSubBuckets - 1
+	lower := uint64(idx%histSubBuckets+histSubBuckets) << shift
+	return lower + (uint64(1)<<shift)/2
+}
+
+func (h *latencyHistogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.counts[histBucket(uint64(d))]++
+	h.ranges[latencyRange(d)]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+	h.sumSq += float64(d) * float64(d)
+}
+
+// latencyRange returns the index of the coarse range d falls into.
+func latencyRange(d time.Duration) int {
+	for i, bound := range latencyRangeBounds {
+		if d < bound {
+			return i
+		}
+	}
+	return len(latencyRangeBounds)
+}
+
+func (h *latencyHistogram) Snapshot() LatencyStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return LatencyStats{}
+	}
+
+	mean := float64(h.sum) / float64(h.count)
+	// Rounding can take the variance of near-identical samples just below 0.
+	variance := max(h.sumSq/float64(h.count)-mean*mean, 0)
+	return LatencyStats{
+		Count:  h.count,
+		Min:    h.min,
+		Avg:    h.sum / time.Duration(h.count),
+		Max:    h.max,
+		P50:    h.percentile(0.50),
+		P95:    h.percentile(0.95),
+		P99:    h.percentile(0.99),
+		StdDev: time.Duration(math.Sqrt(variance)),
+		Ranges: h.ranges,
+	}
+}
+
+// mergeInto adds h's samples to dst, which must not be in use elsewhere.
+func (h *latencyHistogram) mergeInto(dst *latencyHistogram) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count == 0 {
+		return
+	}
+	for idx, c := range h.counts {
+		dst.counts[idx] += c
+	}
+	for i, c := range h.ranges {
+		dst.ranges[i] += c
+	}
+	if dst.count == 0 || h.min < dst.min {
+		dst.min = h.min
+	}
+	dst.max = max(dst.max, h.max)
+	dst.count += h.count
+	dst.sum += h.sum
+	dst.sumSq += h.sumSq
+}
+
+// percentile must be called with h.mu held and h.count > 0.
+func (h *latencyHistogram) percentile(q float64) time.Duration {
+	rank := uint64(q*float64(h.count) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for idx, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			v := time.Duration(histBucketMid(idx))
+			if v < h.min {
+				v = h.min
+			}
+			if v > h.max {
+				v = h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// Record adds d to a randomly picked shard. The per-goroutine global random
+// source keeps the pick itself free of shared state; which shard a sample
+// lands in doesn't affect the results, so it needn't follow Config.Seed.
+func (h *LatencyRecorder) Record(d time.Duration) {
+	h.shards[rand.N(histShards)].Record(d)
+}
+
+// Snapshot returns the distribution of everything recorded so far.
+func (h *LatencyRecorder) Snapshot() LatencyStats {
+	var merged latencyHistogram
+	for i := range h.shards {
+		h.shards[i].mergeInto(&merged)
+	}
+	return merged.Snapshot()
+}
+
+func (s LatencyStats) String() string {
+	if s.Count == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("min=%s avg=%s max=%s stddev=%s p50=%s p95=%s p99=%s (n=%d)",
+		roundLatency(s.Min), roundLatency(s.Avg), roundLatency(s.Max), roundLatency(s.StdDev),
+		roundLatency(s.P50), roundLatency(s.P95), roundLatency(s.P99), s.Count)
+}
+
+// LogValue logs the stats as a group of rounded durations.
+func (s LatencyStats) LogValue() slog.Value {
+	if s.Count == 0 {
+		return slog.GroupValue(slog.Uint64("count", 0))
+	}
+	return slog.GroupValue(
+		slog.Uint64("count", s.Count),
+		slog.Duration("min", roundLatency(s.Min)),
+		slog.Duration("avg", roundLatency(s.Avg)),
+		slog.Duration("max", roundLatency(s.Max)),
+		slog.Duration("stddev", roundLatency(s.StdDev)),
+		slog.Duration("p50", roundLatency(s.P50)),
+		slog.Duration("p95", roundLatency(s.P95)),
+		slog.Duration("p99", roundLatency(s.P99)),
+	)
+}
+
+// latencyDistribution is LatencyStats as the final summary shows it, with the
+// sample counts per coarse range added. A bimodal distribution, with most
+// samples fast and some stalled, shows up there without a plotting tool.
+// Periodic status records leave the ranges out to stay short.
+type latencyDistribution LatencyStats
+
+func (s latencyDistribution) String() string {
+	if s.Count == 0 {
+		return LatencyStats(s).String()
+	}
+	var b strings.Builder
+	b.WriteString(LatencyStats(s).String())
+	for i, c := range s.Ranges {
+		fmt.Fprintf(&b, " %s:%d", latencyRangeNames[i], c)
+	}
+	return b.String()
+}
+
+func (s latencyDistribution) LogValue() slog.Value {
+	stats := LatencyStats(s).LogValue()
+	if s.Count == 0 {
+		return stats
+	}
+	ranges := make([]slog.Attr, latencyRanges)
+	for i, c := range s.Ranges {
+		ranges[i] = slog.Uint64(latencyRangeNames[i], c)
+	}
+	return slog.GroupValue(append(stats.Group(), slog.Attr{Key: "ranges", Value: slog.GroupValue(ranges...)})...)
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
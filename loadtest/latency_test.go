@@ -0,0 +1,99 @@
+package loadtest
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// percentileError is the relative error a reported percentile may have:
+// half the width of a bucket, one sixteenth of its power of two, and a
+// little for the rank rounding.
+const percentileError = 0.07
+
+func TestLatencyRecorderPercentiles(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	tests := []struct {
+		name   string
+		sample func() time.Duration
+	}{
+		{"uniform", func() time.Duration { return time.Duration(1+rng.IntN(10000)) * time.Microsecond }},
+		{"exponential", func() time.Duration { return time.Duration(rng.ExpFloat64() * float64(5*time.Millisecond)) }},
+		// A fast majority and a slow tail, as a server under load gives.
+		{"bimodal", func() time.Duration {
+			if rng.IntN(100) < 97 {
+				return time.Duration(800+rng.IntN(400)) * time.Microsecond
+			}
+			return time.Duration(200+rng.IntN(100)) * time.Millisecond
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rec LatencyRecorder
+			samples := make([]time.Duration, 100000)
+			var sum time.Duration
+			for i := range samples {
+				samples[i] = tt.sample()
+				sum += samples[i]
+				rec.Record(samples[i])
+			}
+			slices.Sort(samples)
+
+			got := rec.Snapshot()
+			if got.Count != uint64(len(samples)) || got.Min != samples[0] || got.Max != samples[len(samples)-1] {
+				t.Errorf("count, min, max = %d, %s, %s, want %d, %s, %s",
+					got.Count, got.Min, got.Max, len(samples), samples[0], samples[len(samples)-1])
+			}
+			if want := sum / time.Duration(len(samples)); got.Avg != want {
+				t.Errorf("avg = %s, want %s", got.Avg, want)
+			}
+			for _, p := range []struct {
+				q   float64
+				got time.Duration
+			}{{0.50, got.P50}, {0.95, got.P95}, {0.99, got.P99}} {
+				want := samples[int(p.q*float64(len(samples)))-1]
+				if math.Abs(float64(p.got-want)) > percentileError*float64(want) {
+					t.Errorf("p%.0f = %s, want %s within %.0f%%", p.q*100, p.got, want, percentileError*100)
+				}
+			}
+		})
+	}
+}
+
+func TestLatencyRecorderConcurrent(t *testing.T) {
+	var rec LatencyRecorder
+	var wg sync.WaitGroup
+	for g := range 50 {
+		wg.Go(func() {
+			for i := range 1000 {
+				rec.Record(time.Duration(g*1000+i) * time.Microsecond)
+			}
+		})
+	}
+	wg.Wait()
+
+	got := rec.Snapshot()
+	if got.Count != 50000 {
+		t.Errorf("count = %d, want 50000", got.Count)
+	}
+	if got.Min != 0 || got.Max != 49999*time.Microsecond {
+		t.Errorf("min, max = %s, %s, want 0s, 49.999ms", got.Min, got.Max)
+	}
+	var ranged uint64
+	for _, c := range got.Ranges {
+		ranged += c
+	}
+	if ranged != got.Count || got.Ranges[0] != 1000 || got.Ranges[1] != 9000 || got.Ranges[2] != 40000 {
+		t.Errorf("ranges = %v, want 1000 under 1ms, 9000 under 10ms and the rest under 100ms", got.Ranges)
+	}
+}
+
+func TestLatencyRecorderEmpty(t *testing.T) {
+	var rec LatencyRecorder
+	if got := rec.Snapshot(); got != (LatencyStats{}) {
+		t.Errorf("snapshot of nothing = %+v, want the zero value", got)
+	}
+}
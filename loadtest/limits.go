@@ -0,0 +1,62 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Cancellation causes for the aggregate limits.
+var (
+	errMaxMessages = errors.New("message limit reached")
+	errMaxBytes    = errors.New("byte limit reached")
+	errErrorRate   = errors.New("error rate exceeded")
+	errMaxRuntime  = errors.New("max runtime reached")
+)
+
+// limitCheckInterval is how often watchLimits reads the totals. The limits
+// may be overshot by up to this much traffic.
+const limitCheckInterval = 100 * time.Millisecond
+
+// watchLimits stops the run through stop once Config.MaxMessages or
+// Config.MaxBytes is crossed, or the error rate trips Config.AbortErrorRate.
+// Whichever limit is seen first is the cause; the context keeps only the
+// first one, so a limit can't override a duration or interrupt that got
+// there earlier.
+func (r *runner) watchLimits(ctx context.Context, stop context.CancelCauseFunc) {
+	ticker := time.NewTicker(limitCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if max := r.cfg.MaxMessages; max > 0 && atomic.LoadInt64(&r.stats.messagesSent) >= max {
+				stop(errMaxMessages)
+				return
+			}
+			if max := r.cfg.MaxBytes; max > 0 && atomic.LoadInt64(&r.stats.bytesRead)+atomic.LoadInt64(&r.stats.bytesWritten) >= max {
+				stop(errMaxBytes)
+				return
+			}
+			if r.errorRateExceeded() {
+				stop(errErrorRate)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// errorRateExceeded reports whether enough connection attempts have been
+// made for Config.AbortErrorRate to apply, and more of them failed than it
+// allows. Attempts during the warmup count too.
+func (r *runner) errorRateExceeded() bool {
+	if r.cfg.AbortErrorRate <= 0 {
+		return false
+	}
+	failed := atomic.LoadInt64(&r.stats.failed)
+	attempts := atomic.LoadInt64(&r.stats.attempts)
+	return attempts >= r.cfg.AbortMinAttempts && float64(failed) > r.cfg.AbortErrorRate*float64(attempts)
+}
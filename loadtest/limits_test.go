@@ -0,0 +1,31 @@
+package loadtest
+
+import "testing"
+
+func TestErrorRateExceeded(t *testing.T) {
+	tests := []struct {
+		name                         string
+		rate                         float64
+		minAttempts                  int64
+		attempts, successful, failed int64
+		want                         bool
+	}{
+		{name: "disabled", rate: 0, minAttempts: 1, attempts: 10, failed: 10},
+		{name: "too few attempts", rate: 0.5, minAttempts: 100, attempts: 99, failed: 99},
+		{name: "at the rate", rate: 0.5, minAttempts: 10, attempts: 10, successful: 5, failed: 5},
+		{name: "over the rate", rate: 0.5, minAttempts: 10, attempts: 10, successful: 4, failed: 6, want: true},
+		// Dials still in flight count as attempts, as they do everywhere
+		// else.
+		{name: "in flight", rate: 0.5, minAttempts: 10, attempts: 20, successful: 4, failed: 6},
+		{name: "in flight reach the minimum", rate: 0.5, minAttempts: 10, attempts: 10, failed: 6, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &runner{cfg: Config{AbortErrorRate: tt.rate, AbortMinAttempts: tt.minAttempts}}
+			r.stats.attempts, r.stats.successful, r.stats.failed = tt.attempts, tt.successful, tt.failed
+			if got := r.errorRateExceeded(); got != tt.want {
+				t.Errorf("errorRateExceeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
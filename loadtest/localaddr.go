@@ -0,0 +1,53 @@
+package loadtest
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// parseLocalAddr validates a source IP. Binding a throwaway listener to it
+// makes sure the address belongs to this host, so a typo fails at startup
+// rather than on every dial.
+func parseLocalAddr(s string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IP address", s)
+	}
+	addr := &net.TCPAddr{IP: ip}
+	ln, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not usable on this host: %w", s, err)
+	}
+	ln.Close()
+	return addr, nil
+}
+
+// addrPool hands out dialers bound to a set of source addresses
+// round-robin, one per worker, so workers spread evenly across them. It is
+// only used by the run loop and needs no locking.
+type addrPool struct {
+	dialers []*websocket.Dialer
+	n       int
+}
+
+// newAddrPool derives one dialer per address from base, each with
+// connectTimeout as its connect timeout.
+func newAddrPool(base *websocket.Dialer, addrs []*net.TCPAddr, connectTimeout time.Duration) *addrPool {
+	p := &addrPool{}
+	for _, addr := range addrs {
+		d := *base
+		d.NetDialContext = connectFunc(addr, connectTimeout)
+		p.dialers = append(p.dialers, &d)
+	}
+	return p
+}
+
+// next returns the dialer for the next address in turn.
+func (p *addrPool) next() *websocket.Dialer {
+	d := p.dialers[p.n%len(p.dialers)]
+	p.n++
+	return d
+}
@@ -0,0 +1,78 @@
+package loadtest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAddrPoolDistributesEvenly(t *testing.T) {
+	addrs := []*net.TCPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("10.0.0.3")},
+	}
+	p := newAddrPool(&websocket.Dialer{}, addrs, 0)
+	if len(p.dialers) != len(addrs) {
+		t.Fatalf("%d dialers, want one per address", len(p.dialers))
+	}
+
+	counts := make(map[*websocket.Dialer]int)
+	for range 3*len(addrs) + 1 {
+		counts[p.next()]++
+	}
+	for i, d := range p.dialers {
+		// The one left over goes to the first address.
+		want := 3
+		if i == 0 {
+			want = 4
+		}
+		if counts[d] != want {
+			t.Errorf("address %s got %d workers, want %d", addrs[i].IP, counts[d], want)
+		}
+	}
+}
+
+func TestAddrPoolDialsFromEachAddress(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	url := newWSServer(t, func(conn *websocket.Conn, req *http.Request) {
+		host, _, _ := net.SplitHostPort(req.RemoteAddr)
+		mu.Lock()
+		seen[host]++
+		mu.Unlock()
+		conn.ReadMessage()
+	})
+	cfg := testConfig(url)
+	cfg.Concurrency = 4
+	cfg.LocalAddrs = []string{"127.0.0.2", "127.0.0.3"}
+	cfg.Duration = 100 * time.Millisecond
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, addr := range cfg.LocalAddrs {
+		if seen[addr] != 2 {
+			t.Errorf("%s opened %d connections, want 2 (all: %v)", addr, seen[addr], seen)
+		}
+	}
+}
+
+func TestParseLocalAddr(t *testing.T) {
+	if _, err := parseLocalAddr("127.0.0.1"); err != nil {
+		t.Errorf("parseLocalAddr(127.0.0.1): %v", err)
+	}
+	// Not an address, and an address that isn't this host's.
+	for _, s := range []string{"localhost", "192.0.2.1"} {
+		if _, err := parseLocalAddr(s); err == nil {
+			t.Errorf("parseLocalAddr(%q) succeeded, want an error", s)
+		}
+	}
+}
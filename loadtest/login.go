@@ -0,0 +1,178 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTokenPath is where the login token is looked for when neither
+// Config.LoginTokenPath nor Config.LoginTokenRegex is set.
+const defaultTokenPath = "token"
+
+// maxLoginResponse bounds how much of the login response is read.
+const maxLoginResponse = 1 << 20
+
+// login performs the Config.LoginURL request and returns the token it
+// yields. It goes through the same proxy, TLS, connect timeout and source
+// address settings as the handshakes.
+func (r *runner) login(ctx context.Context) (string, error) {
+	cfg := r.cfg
+	method := cfg.LoginMethod
+	if method == "" {
+		method = http.MethodGet
+		if cfg.LoginBody != "" {
+			method = http.MethodPost
+		}
+	}
+
+	if cfg.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.HandshakeTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cfg.LoginURL, strings.NewReader(cfg.LoginBody))
+	if err != nil {
+		return "", err
+	}
+	if cfg.LoginBody != "" {
+		req.Header.Set("Content-Type", loginContentType(cfg.LoginBody))
+	}
+
+	// The first source address will do: the login is a single request.
+	dialer := r.dialer
+	if r.sources != nil {
+		dialer = r.sources.dialers[0]
+	}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           dialer.Proxy,
+		DialContext:     dialer.NetDialContext,
+		TLSClientConfig: dialer.TLSClientConfig,
+	}}
+	defer client.CloseIdleConnections()
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxLoginResponse))
+	if err != nil {
+		return "", fmt.Errorf("reading the response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		text := string(body)
+		if len(body) > maxLoggedBody {
+			text = string(body[:maxLoggedBody]) + "..."
+		}
+		text = strings.TrimSpace(text)
+		return "", fmt.Errorf("server answered %s: %s", resp.Status, text)
+	}
+
+	token, err := r.extractToken(body)
+	if err != nil {
+		return "", err
+	}
+	r.log.Info("Logged in", "url", cfg.LoginURL, "status", resp.StatusCode, "took", time.Since(start).Round(time.Millisecond))
+	return token, nil
+}
+
+// loginContentType guesses the type of a login body: JSON if it looks like
+// it, otherwise a URL-encoded form.
+func loginContentType(body string) string {
+	if b := strings.TrimSpace(body); strings.HasPrefix(b, "{") || strings.HasPrefix(b, "[") {
+		return "application/json"
+	}
+	return "application/x-www-form-urlencoded"
+}
+
+// extractToken finds the token in a login response, with r.loginRegex if
+// set and by Config.LoginTokenPath otherwise.
+func (r *runner) extractToken(body []byte) (string, error) {
+	if r.loginRegex != nil {
+		m := r.loginRegex.FindSubmatch(body)
+		switch {
+		case m == nil:
+			return "", fmt.Errorf("the response doesn't match --login-token-regex %q", r.cfg.LoginTokenRegex)
+		case len(m) > 1:
+			return string(m[1]), nil
+		default:
+			return string(m[0]), nil
+		}
+	}
+
+	path := r.cfg.LoginTokenPath
+	if path == "" {
+		path = defaultTokenPath
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return "", fmt.Errorf("the response isn't JSON: %w", err)
+	}
+	token, err := jsonPath(doc, path)
+	if err != nil {
+		return "", fmt.Errorf("no token at --login-token-path %q: %w", path, err)
+	}
+	return token, nil
+}
+
+// jsonPath follows a dotted path such as "data.tokens.0.value" through a
+// decoded JSON document, numeric elements indexing arrays, and returns the
+// string or number at its end.
+func jsonPath(doc any, path string) (string, error) {
+	v := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("%q not found", key)
+			}
+			v = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("%q is not an index into an array of %d", key, len(node))
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("%q is not inside an object or array", key)
+		}
+	}
+
+	switch token := v.(type) {
+	case string:
+		if token == "" {
+			return "", errors.New("the token is empty")
+		}
+		return token, nil
+	case json.Number:
+		return token.String(), nil
+	default:
+		return "", errors.New("the value is not a string")
+	}
+}
+
+// compileLoginRegex compiles Config.LoginTokenRegex, which may have at most
+// one capture group; the token is the group, or the whole match without one.
+func compileLoginRegex(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() > 1 {
+		return nil, errors.New("at most one capture group is allowed")
+	}
+	return re, nil
+}
@@ -0,0 +1,60 @@
+package loadtest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoginUsesDialerSettings(t *testing.T) {
+	var remote string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		remote, _, _ = net.SplitHostPort(req.RemoteAddr)
+		w.Write([]byte(`{"data": {"token": "abc"}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := testConfig(wsURL(srv.URL))
+	cfg.LoginURL = srv.URL
+	cfg.LoginTokenPath = "data.token"
+	cfg.LocalAddrs = []string{"127.0.0.2"}
+	cfg.ConnectTimeout = time.Second
+	// No handshake timeout must not mean an expired login deadline.
+	cfg.HandshakeTimeout = 0
+	r, err := newRunner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := r.login(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "abc" {
+		t.Errorf("token = %q, want %q", token, "abc")
+	}
+	if remote != "127.0.0.2" {
+		t.Errorf("login came from %s, want the source address 127.0.0.2", remote)
+	}
+}
+
+func TestLoginFailsOnErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "bad credentials", http.StatusUnauthorized)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := testConfig(wsURL(srv.URL))
+	cfg.LoginURL = srv.URL
+	cfg.LoginBody = `{"user": "u"}`
+	r, err := newRunner(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.login(context.Background()); err == nil {
+		t.Fatal("login succeeded, want the 401 reported")
+	}
+}
@@ -0,0 +1,93 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newMetricsRegistry exposes the run counters as Prometheus metrics. The
+// metrics read the atomics at scrape time, so nothing extra is recorded on
+// the hot path.
+func (r *runner) newMetricsRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+
+	counter := func(name, help string, v *int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help},
+			func() float64 { return float64(atomic.LoadInt64(v)) })
+	}
+
+	reg.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sockstorm_active_connections",
+			Help: "Currently open WebSocket connections.",
+		}, func() float64 { return float64(atomic.LoadInt64(&r.stats.active)) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sockstorm_peak_active_connections",
+			Help: "Most WebSocket connections open at once so far.",
+		}, func() float64 { return float64(r.peakActive.Load()) }),
+		counter("sockstorm_attempts_total", "Dial attempts, including reconnects.", &r.stats.attempts),
+		counter("sockstorm_successful_total", "Connections successfully established, including reconnections.", &r.stats.successful),
+		counter("sockstorm_failed_total", "Failed connection attempts.", &r.stats.failed),
+		counter("sockstorm_reconnects_total", "Dial attempts made after a worker's first one.", &r.stats.reconnects),
+		counter("sockstorm_bytes_read_total", "Bytes received across all connections.", &r.stats.bytesRead),
+		counter("sockstorm_messages_sent_total", "Messages sent across all connections.", &r.stats.messagesSent),
+		counter("sockstorm_bytes_written_total", "Bytes sent across all connections.", &r.stats.bytesWritten),
+		counter("sockstorm_pings_sent_total", "Ping frames sent across all connections.", &r.stats.pingsSent),
+		counter("sockstorm_heartbeats_sent_total", "Application-level heartbeat messages sent across all connections.", &r.stats.heartbeatsSent),
+		counter("sockstorm_replays_completed_total", "Passes through the replay file that sent every message.", &r.stats.replaysCompleted),
+		counter("sockstorm_pongs_received_total", "Pong frames received across all connections.", &r.stats.pongsReceived),
+		counter("sockstorm_server_pings_received_total", "Ping frames received from the server across all connections.", &r.stats.pingsReceived),
+		counter("sockstorm_clean_closes_total", "Client-initiated closes the server answered with a close frame in time.", &r.stats.cleanCloses),
+		counter("sockstorm_abrupt_closes_total", "Client-initiated closes the server didn't answer in time.", &r.stats.abruptCloses),
+		counter("sockstorm_pongs_sent_total", "Pong frames sent in answer to server pings.", &r.stats.pongsSent),
+		counter("sockstorm_assertion_failures_total", "Received text messages that didn't match --expect.", &r.stats.assertionFailures),
+		counter("sockstorm_responses_total", "Correlated requests answered within --response-timeout.", &r.stats.responses),
+		counter("sockstorm_response_timeouts_total", "Correlated requests not answered within --response-timeout.", &r.stats.responseTimeouts),
+		counter("sockstorm_oversized_messages_total", "Connections closed because a message exceeded --max-message-size.", &r.stats.oversized),
+		counter("sockstorm_compressed_connections_total", "Connections that negotiated permessage-deflate.", &r.stats.compressed),
+		counter("sockstorm_stalled_connections_total", "Connections closed because nothing was sent or received within --idle-timeout.", &r.stats.stalled),
+		counter("sockstorm_backpressured_connections_total", "Connections that sent more than --backpressure-gap messages beyond those they received.", &r.stats.backpressured),
+		counter("sockstorm_churned_total", "Connections closed because their lifetime was reached.", &r.stats.churned),
+	)
+	return reg
+}
+
+// serveMetrics serves /metrics on addr until the returned func is called,
+// which shuts the server down and waits for it, so the final counters can
+// still be scraped while connections close.
+func (r *runner) serveMetrics(addr string) (func(), error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(r.newMetricsRegistry(), promhttp.HandlerOpts{}))
+
+	// Listen up front so bind errors such as "address already in use" are
+	// reported at startup.
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			r.log.Error("Metrics server stopped", "err", err)
+		}
+	}()
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			r.log.Error("Failed to shut down metrics server", "err", err)
+		}
+		<-stopped
+	}, nil
+}
@@ -0,0 +1,50 @@
+package loadtest
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsServedUntilWorkersReturn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := testConfig(newEchoServer(t))
+	cfg.MetricsAddr = addr
+	cfg.Duration = 100 * time.Millisecond
+	// The two workers take a second to stop once the duration is up.
+	cfg.RampDownRate = 2
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := Run(context.Background(), cfg)
+		done <- err
+	}()
+
+	time.Sleep(cfg.Duration + 300*time.Millisecond)
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("metrics gone during ramp-down: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "sockstorm_active_connections") {
+		t.Errorf("metrics lack sockstorm_active_connections:\n%s", body)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get("http://" + addr + "/metrics"); err == nil {
+		t.Error("metrics still served after the run")
+	}
+}
@@ -0,0 +1,115 @@
+package loadtest
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Pattern decides how many connections should be open at each point of a
+// run. The runner opens connections at Config.Rate until the target is
+// reached and closes the newest ones as soon as it drops, never going above
+// Config.Concurrency.
+type Pattern interface {
+	// Target returns the desired number of open connections elapsed time
+	// after the run started.
+	Target(elapsed time.Duration) int
+}
+
+// ConstantPattern holds Concurrency connections for the whole run. This is
+// the default.
+type ConstantPattern struct {
+	Concurrency int
+}
+
+func (p ConstantPattern) Target(time.Duration) int { return p.Concurrency }
+
+func (p ConstantPattern) String() string { return "constant" }
+
+// RampPattern climbs linearly from zero to Concurrency over Over, then holds.
+type RampPattern struct {
+	Concurrency int
+	Over        time.Duration
+}
+
+func (p RampPattern) Target(elapsed time.Duration) int {
+	if elapsed >= p.Over {
+		return p.Concurrency
+	}
+	return int(int64(p.Concurrency) * int64(elapsed) / int64(p.Over))
+}
+
+func (p RampPattern) String() string { return fmt.Sprintf("ramp to %d over %s", p.Concurrency, p.Over) }
+
+// SpikePattern holds Base connections and surges to Peak for the last Hold
+// of every Every, simulating periodic traffic spikes.
+type SpikePattern struct {
+	Base, Peak  int
+	Every, Hold time.Duration
+}
+
+func (p SpikePattern) Target(elapsed time.Duration) int {
+	if elapsed%p.Every >= p.Every-p.Hold {
+		return p.Peak
+	}
+	return p.Base
+}
+
+func (p SpikePattern) String() string {
+	return fmt.Sprintf("spike from %d to %d for %s every %s", p.Base, p.Peak, p.Hold, p.Every)
+}
+
+// StepPattern adds Concurrency/Steps connections every Every until it reaches
+// Concurrency, holds that for one more Every, then drops back to the first
+// step and climbs again, producing a sawtooth.
+type StepPattern struct {
+	Concurrency int
+	Steps       int
+	Every       time.Duration
+}
+
+func (p StepPattern) Target(elapsed time.Duration) int {
+	step := int(elapsed/p.Every) % (p.Steps + 1)
+	if step >= p.Steps {
+		step = p.Steps - 1
+	}
+	return p.Concurrency * (step + 1) / p.Steps
+}
+
+func (p StepPattern) String() string {
+	return fmt.Sprintf("step to %d in %d steps of %s", p.Concurrency, p.Steps, p.Every)
+}
+
+// patternNames lists the patterns NewPattern understands.
+const patternNames = "constant, ramp, spike or step"
+
+// NewPattern builds one of the named patterns shaped around concurrency
+// connections and period:
+//
+//   - constant holds concurrency connections.
+//   - ramp climbs linearly to concurrency over period, then holds.
+//   - spike holds a tenth of concurrency and surges to all of it for the
+//     last fifth of every period.
+//   - step climbs to concurrency in five steps of period each, holds for
+//     one more period, then starts over.
+func NewPattern(name string, concurrency int, period time.Duration) (Pattern, error) {
+	if name != "constant" && period <= 0 {
+		return nil, fmt.Errorf("pattern %q requires a positive period", name)
+	}
+	switch name {
+	case "constant":
+		return ConstantPattern{Concurrency: concurrency}, nil
+	case "ramp":
+		return RampPattern{Concurrency: concurrency, Over: period}, nil
+	case "spike":
+		return SpikePattern{
+			Base:  max(concurrency/10, 1),
+			Peak:  concurrency,
+			Every: period,
+			Hold:  period / 5,
+		}, nil
+	case "step":
+		return StepPattern{Concurrency: concurrency, Steps: 5, Every: period}, nil
+	default:
+		return nil, fmt.Errorf("unknown pattern %q, must be %s", name, patternNames)
+	}
+}
@@ -0,0 +1,62 @@
+package loadtest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPatternTimelines(t *testing.T) {
+	s := time.Second
+	tests := []struct {
+		name string
+		// timeline maps elapsed times to the targets wanted then.
+		timeline map[time.Duration]int
+	}{
+		{"constant", map[time.Duration]int{0: 100, 10 * s: 100, time.Hour: 100}},
+		{"ramp", map[time.Duration]int{0: 0, 2500 * time.Millisecond: 25, 5 * s: 50, 10 * s: 100, time.Hour: 100}},
+		// A tenth, surging to all for the last 2s of every 10s.
+		{"spike", map[time.Duration]int{0: 10, 7999 * time.Millisecond: 10, 8 * s: 100, 9999 * time.Millisecond: 100, 10 * s: 10, 18 * s: 100}},
+		// Five steps of 20 every 10s, held for one more step, then again.
+		{"step", map[time.Duration]int{0: 20, 10 * s: 40, 25 * s: 60, 30 * s: 80, 40 * s: 100, 50 * s: 100, 60 * s: 20, 70 * s: 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPattern(tt.name, 100, 10*s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for elapsed, want := range tt.timeline {
+				if got := p.Target(elapsed); got != want {
+					t.Errorf("Target(%s) = %d, want %d", elapsed, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPatternSpikeBaseAtLeastOne(t *testing.T) {
+	p, err := NewPattern("spike", 5, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Target(0); got != 1 {
+		t.Errorf("Target(0) = %d, want 1", got)
+	}
+}
+
+func TestNewPatternInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		period time.Duration
+	}{
+		{"ramp", 0},
+		{"spike", -time.Second},
+		{"step", 0},
+		{"sine", time.Second},
+	}
+	for _, tt := range tests {
+		if _, err := NewPattern(tt.name, 10, tt.period); err == nil {
+			t.Errorf("NewPattern(%q, 10, %s) succeeded, want an error", tt.name, tt.period)
+		}
+	}
+}
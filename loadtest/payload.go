@@ -0,0 +1,98 @@
+package loadtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// maxPayloadSize bounds a payload file. Every send goes out as a single
+// frame, and servers typically refuse messages far smaller than this, so a
+// larger file is almost certainly a mistake.
+const maxPayloadSize = 16 << 20
+
+// loadPayload reads a payload file into memory, or stdin for "-". The bytes
+// are shared by all workers and never modified.
+func loadPayload(path string) ([]byte, error) {
+	if path == "-" {
+		return loadStdinPayload()
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	if info.Size() > maxPayloadSize {
+		return nil, fmt.Errorf("%s is %d bytes, over the %d-byte limit for a single frame", path, info.Size(), maxPayloadSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return data, nil
+}
+
+// loadStdinPayload reads the payload piped into stdin up to EOF. A terminal
+// on stdin is refused rather than waited on for input that never comes.
+func loadStdinPayload() ([]byte, error) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return nil, fmt.Errorf("stdin is a terminal, not a pipe or file; pipe the payload in, e.g. cat frame.bin | go-socket-storm --payload-file - ...")
+	}
+	data, err := io.ReadAll(io.LimitReader(os.Stdin, maxPayloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading stdin: %w", err)
+	}
+	if len(data) > maxPayloadSize {
+		return nil, fmt.Errorf("stdin holds over the %d-byte limit for a single frame", maxPayloadSize)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("stdin is empty")
+	}
+	return data, nil
+}
+
+// payloadData holds the variables available to a --send template.
+type payloadData struct {
+	// ConnID identifies the worker; it stays the same across reconnects.
+	ConnID uint64
+	// Seq counts the messages sent on the current connection, from 0.
+	Seq uint64
+	// Timestamp is the send time in Unix nanoseconds.
+	Timestamp int64
+}
+
+// parsePayloadTemplate parses text as a text/template. It returns nil if
+// text has no actions, so plain payloads are sent as-is. The template is
+// rendered once here so unknown fields are reported before any worker
+// starts.
+func parsePayloadTemplate(text string) (*template.Template, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	tmpl, err := template.New("send").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, payloadData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// renderPayload executes tmpl into buf and returns the result, which is only
+// valid until buf is next used.
+func renderPayload(tmpl *template.Template, buf *bytes.Buffer, data payloadData) ([]byte, error) {
+	buf.Reset()
+	if err := tmpl.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
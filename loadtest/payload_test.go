@@ -0,0 +1,107 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRenderPayload(t *testing.T) {
+	data := payloadData{ConnID: 7, Seq: 3, Timestamp: 1700000000000000000}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`{"conn":{{.ConnID}},"seq":{{.Seq}}}`, `{"conn":7,"seq":3}`},
+		{`at {{.Timestamp}}`, `at 1700000000000000000`},
+		{`{{printf "%03d" .Seq}}-{{.ConnID}}`, `003-7`},
+	}
+	var buf bytes.Buffer
+	for _, tt := range tests {
+		tmpl, err := parsePayloadTemplate(tt.text)
+		if err != nil {
+			t.Fatalf("parsePayloadTemplate(%q): %v", tt.text, err)
+		}
+		got, err := renderPayload(tmpl, &buf, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("rendering %q = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParsePayloadTemplate(t *testing.T) {
+	tests := []struct {
+		text     string
+		template bool
+		wantErr  bool
+	}{
+		// Plain payloads, braces and all, are sent as they are.
+		{text: `{"type":"ping"}`},
+		{text: `{{.Seq}}`, template: true},
+		{text: `{{.Seq`, wantErr: true},
+		// Unknown fields are caught before any worker starts.
+		{text: `{{.UserID}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		tmpl, err := parsePayloadTemplate(tt.text)
+		switch {
+		case tt.wantErr:
+			if err == nil {
+				t.Errorf("parsePayloadTemplate(%q) succeeded, want an error", tt.text)
+			}
+		case err != nil:
+			t.Errorf("parsePayloadTemplate(%q): %v", tt.text, err)
+		case (tmpl != nil) != tt.template:
+			t.Errorf("parsePayloadTemplate(%q) = %v, want a template: %v", tt.text, tmpl, tt.template)
+		}
+	}
+}
+
+func TestTemplatedSendsRenderedPerMessage(t *testing.T) {
+	got := make(chan []string, 2)
+	url := newWSServer(t, func(conn *websocket.Conn, _ *http.Request) {
+		var messages []string
+		defer func() { got <- messages }()
+		for {
+			_, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages = append(messages, string(p))
+		}
+	})
+	cfg := testConfig(url)
+	cfg.Send = "{{.ConnID}}-{{.Seq}}"
+	cfg.SendRate = 50
+	cfg.Duration = 200 * time.Millisecond
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := make(map[string]bool)
+	for range cfg.Concurrency {
+		messages := <-got
+		if len(messages) < 2 {
+			t.Fatalf("server got %q, want a few messages", messages)
+		}
+		id, _, _ := strings.Cut(messages[0], "-")
+		conns[id] = true
+		for seq, m := range messages {
+			if want := fmt.Sprintf("%s-%d", id, seq); m != want {
+				t.Errorf("message %d = %q, want %q", seq, m, want)
+			}
+		}
+	}
+	if len(conns) != cfg.Concurrency {
+		t.Errorf("connection ids %v, want %d different ones", conns, cfg.Concurrency)
+	}
+}
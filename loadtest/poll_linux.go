@@ -0,0 +1,80 @@
+package loadtest
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+)
+
+// pollSupported reports whether Config.PoolSize can be used on this platform.
+const pollSupported = true
+
+// poller is an epoll instance, level-triggered, that wakes a pool goroutine
+// when any of its connections has something to read.
+type poller struct {
+	fd     int
+	events []syscall.EpollEvent
+}
+
+func newPoller() (*poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &poller{fd: fd, events: make([]syscall.EpollEvent, pollEvents)}, nil
+}
+
+func (p *poller) add(fd int) error {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_ADD, fd, &ev)
+}
+
+// remove stops watching fd. It must be called before fd is closed, so a new
+// connection given the same descriptor isn't mistaken for the old one.
+func (p *poller) remove(fd int) {
+	syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait appends the descriptors that are readable, or have been hung up on,
+// to fds, waiting at most timeout for one to be.
+func (p *poller) wait(fds []int, timeout time.Duration) ([]int, error) {
+	n, err := syscall.EpollWait(p.fd, p.events, int(timeout/time.Millisecond))
+	if errors.Is(err, syscall.EINTR) {
+		return fds, nil
+	}
+	if err != nil {
+		return fds, err
+	}
+	for _, ev := range p.events[:n] {
+		fds = append(fds, int(ev.Fd))
+	}
+	return fds, nil
+}
+
+func (p *poller) close() error {
+	return syscall.Close(p.fd)
+}
+
+// readNow reads what the socket behind raw holds without waiting for more.
+// It returns 0 and no error if there is nothing to read yet.
+func readNow(raw syscall.RawConn, buf []byte) (int, error) {
+	var n int
+	var readErr error
+	err := raw.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), buf)
+		// Done either way: the pool only reads what has already arrived.
+		return true
+	})
+	switch {
+	case err != nil:
+		return 0, err
+	case errors.Is(readErr, syscall.EAGAIN), errors.Is(readErr, syscall.EINTR):
+		return 0, nil
+	case readErr != nil:
+		return 0, readErr
+	case n == 0:
+		return 0, io.EOF
+	}
+	return n, nil
+}
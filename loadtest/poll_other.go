@@ -0,0 +1,32 @@
+//go:build !linux
+
+package loadtest
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// pollSupported reports whether Config.PoolSize can be used on this platform.
+const pollSupported = false
+
+var errPollUnsupported = errors.New("the connection pool is only supported on Linux")
+
+// poller stands in for the epoll instance of Linux; validation keeps it from
+// ever being created.
+type poller struct{}
+
+func newPoller() (*poller, error) { return nil, errPollUnsupported }
+
+func (p *poller) add(fd int) error { return errPollUnsupported }
+
+func (p *poller) remove(fd int) {}
+
+func (p *poller) wait(fds []int, timeout time.Duration) ([]int, error) {
+	return fds, errPollUnsupported
+}
+
+func (p *poller) close() error { return nil }
+
+func readNow(raw syscall.RawConn, buf []byte) (int, error) { return 0, errPollUnsupported }
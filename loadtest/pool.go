@@ -0,0 +1,596 @@
+package loadtest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// pollEvents is how many ready connections one wait hands a pool
+	// goroutine at most.
+	pollEvents = 256
+	// pollReadBuffer is how much a pool goroutine reads from a connection
+	// at a time.
+	pollReadBuffer = 64 << 10
+	// pollWait bounds each wait, so a pool goroutine notices close
+	// handshakes that timed out, connections closed elsewhere and being
+	// stopped.
+	pollWait = 50 * time.Millisecond
+	// pooledReadBuffer is gorilla/websocket's read buffer for pooled
+	// connections. It only ever holds messages that have arrived in full,
+	// so it needn't be large.
+	pooledReadBuffer = 512
+)
+
+// headerEnd ends the header of an HTTP response.
+const headerEnd = "\r\n\r\n"
+
+// errPoolUnderrun is what a pooled connection's reader gets if it reads past
+// the messages the pool has gathered for it, which the pool never lets it.
+var errPoolUnderrun = errors.New("read past the messages received")
+
+// connPool holds the connections of Config.PoolSize on a fixed set of
+// goroutines, each waiting for any of a group of connections to become
+// readable and reading only what has arrived, in place of a goroutine per
+// connection blocked in ReadMessage. Connections are spread over the groups
+// round-robin.
+type connPool struct {
+	groups []*pollGroup
+	next   atomic.Uint64
+	stop   chan struct{}
+	done   sync.WaitGroup
+}
+
+// pollGroup is the connections one pool goroutine serves, by descriptor.
+type pollGroup struct {
+	poller *poller
+	mu     sync.Mutex
+	conns  map[int]*pooledConn
+	// closing are the connections shutting down, whose servers have until
+	// their closeBy to answer the close frame, and closed those closed by
+	// someone other than the pool, such as the soak pinger after a failed
+	// ping.
+	closing []*pooledConn
+	closed  []*pooledConn
+}
+
+// pooledConn is a connection the pool holds on behalf of its worker.
+type pooledConn struct {
+	ctx      context.Context
+	w        *workerState
+	sc       *safeConn
+	pc       *pollConn
+	group    *pollGroup
+	openedAt time.Time
+	// stopShutdown cancels the close frame sent once ctx is done, and
+	// removeSoak takes the connection off the soak pinger.
+	stopShutdown func() bool
+	removeSoak   func()
+	// closeBy is when the server's answer to the close frame is due, or
+	// zero if the close frame couldn't be sent. It is guarded by the
+	// group's mu.
+	closeBy time.Time
+	// ended is set by whichever of the pool's paths ends the connection
+	// first.
+	ended atomic.Bool
+	// lastMessage and firstMessage are as in runConnection, and only used
+	// by the pool goroutine.
+	lastMessage  time.Time
+	firstMessage bool
+}
+
+// pollConn is the net.Conn under a pooled websocket.Conn. During the
+// handshake it reads the socket as usual but hands out the response only up
+// to the end of its header, so that gorilla/websocket's buffer never holds
+// frames the pool hasn't seen. Once the pool holds the connection, the pool
+// reads the socket without waiting, answers pings itself and passes the
+// websocket.Conn nothing but messages that have arrived in full.
+type pollConn struct {
+	net.Conn
+	raw syscall.RawConn
+	fd  int
+	// held is set once the pool holds the connection.
+	held atomic.Pointer[pooledConn]
+	// in holds what has been read from the socket but not passed on:
+	// during the handshake what follows the header it has got to, then the
+	// start of a frame that hasn't arrived in full.
+	in []byte
+	// match is how much of headerEnd the bytes passed on so far end with.
+	match int
+	// ready holds the frames of messages that have arrived in full, and of
+	// close frames, for the websocket.Conn to read; messages counts them.
+	// pending is the payload of the fragments of a message still arriving.
+	ready    []byte
+	messages int
+	pending  int64
+}
+
+// pollDial wraps a dialer's NetDialContext so its connections can be held by
+// the pool. Only plain TCP and Unix socket connections can be.
+func pollDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		sysConn, ok := conn.(syscall.Conn)
+		if !ok {
+			conn.Close()
+			return nil, errors.New("the connection pool (--pool-size) needs plain TCP or Unix socket connections")
+		}
+		raw, err := sysConn.SyscallConn()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		pc := &pollConn{Conn: conn, raw: raw}
+		raw.Control(func(fd uintptr) { pc.fd = int(fd) })
+		return pc, nil
+	}
+}
+
+func (c *pollConn) Read(p []byte) (int, error) {
+	if c.held.Load() != nil {
+		if len(c.ready) == 0 {
+			return 0, errPoolUnderrun
+		}
+		n := copy(p, c.ready)
+		c.ready = c.ready[n:]
+		if len(c.ready) == 0 {
+			c.ready = nil
+		}
+		return n, nil
+	}
+
+	if len(c.in) == 0 {
+		n, err := c.Conn.Read(p)
+		if n == 0 {
+			return 0, err
+		}
+		c.in = append(c.in, p[:n]...)
+	}
+	n := min(len(p), len(c.in))
+	for i, b := range c.in[:n] {
+		switch {
+		case b == headerEnd[c.match]:
+			c.match++
+		case b == '\r':
+			c.match = 1
+		default:
+			c.match = 0
+		}
+		if c.match == len(headerEnd) {
+			c.match = 0
+			n = i + 1
+			break
+		}
+	}
+	copy(p, c.in[:n])
+	c.in = c.in[n:]
+	return n, nil
+}
+
+// Close takes the connection out of its group before closing it, so the
+// descriptor can't be reused while the pool still watches it, and has the
+// pool end it if it didn't close it itself.
+func (c *pollConn) Close() error {
+	if p := c.held.Load(); p != nil && p.group.detach(p) {
+		p.group.mu.Lock()
+		p.group.closed = append(p.group.closed, p)
+		p.group.mu.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+// split moves the frames that have arrived in full from in to ready,
+// answering pings and counting pongs on the way. It returns
+// websocket.ErrReadLimit if a message grows past Config.MaxMessageSize.
+func (r *runner) split(p *pooledConn) error {
+	c := p.pc
+	for {
+		header, length, ok := frameSize(c.in)
+		if !ok {
+			break
+		}
+		opcode, fin, masked := int(c.in[0]&0x0f), c.in[0]&0x80 != 0, c.in[1]&0x80 != 0
+		if limit := r.cfg.MaxMessageSize; limit > 0 && opcode < websocket.CloseMessage && c.pending+length > limit {
+			return websocket.ErrReadLimit
+		}
+		if int64(len(c.in)-header) < length {
+			break
+		}
+		frame := c.in[:header+int(length)]
+		c.in = c.in[len(frame):]
+
+		switch {
+		case masked:
+			// Servers mustn't mask their frames; the websocket.Conn
+			// rejects it.
+			c.ready = append(c.ready, frame...)
+			c.messages++
+		case opcode == websocket.PingMessage:
+			atomic.AddInt64(&r.stats.pingsReceived, 1)
+			p.sc.log.Debug("Received ping")
+			if err := r.sendPong(p.sc, string(frame[header:])); err != nil {
+				p.sc.log.Debug("Pong failed", "err", err)
+			}
+		case opcode == websocket.PongMessage:
+			atomic.AddInt64(&r.stats.pongsReceived, 1)
+			p.sc.log.Debug("Received pong")
+		case opcode == websocket.CloseMessage:
+			c.ready = append(c.ready, frame...)
+			c.messages++
+		default:
+			c.ready = append(c.ready, frame...)
+			c.pending += length
+			if fin {
+				c.messages++
+				c.pending = 0
+			}
+		}
+	}
+	if len(c.in) == 0 {
+		c.in = nil
+	}
+	return nil
+}
+
+// frameSize returns the header and payload lengths of the frame at the start
+// of b, or false if its header hasn't arrived in full.
+func frameSize(b []byte) (header int, length int64, ok bool) {
+	if len(b) < 2 {
+		return 0, 0, false
+	}
+	header, length = 2, int64(b[1]&0x7f)
+	switch length {
+	case 126:
+		header += 2
+	case 127:
+		header += 8
+	}
+	if b[1]&0x80 != 0 {
+		header += 4
+	}
+	if len(b) < header {
+		return 0, 0, false
+	}
+	switch length {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(b[2:]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(b[2:]) & (1<<63 - 1))
+	}
+	return header, length, true
+}
+
+// startPool creates the Config.PoolSize pool goroutines. They run until
+// stopPool.
+func (r *runner) startPool() error {
+	p := r.pool
+	p.stop = make(chan struct{})
+	for range r.cfg.PoolSize {
+		poller, err := newPoller()
+		if err != nil {
+			r.stopPool()
+			return err
+		}
+		g := &pollGroup{poller: poller, conns: make(map[int]*pooledConn)}
+		p.groups = append(p.groups, g)
+		p.done.Add(1)
+		go func() {
+			defer p.done.Done()
+			r.servePollGroup(g, p.stop)
+		}()
+	}
+	return nil
+}
+
+// stopPool stops the pool goroutines. Connections still open stay open.
+func (r *runner) stopPool() {
+	p := r.pool
+	close(p.stop)
+	p.done.Wait()
+	for _, g := range p.groups {
+		g.poller.close()
+	}
+}
+
+// holdPooled hands one of w's connections to the pool. Whatever arrived with
+// the handshake response is dealt with here, before the pool watches the
+// connection; from then on the pool goroutine reads it, and resumes w once
+// it ends.
+func (r *runner) holdPooled(ctx context.Context, w *workerState, sc *safeConn, openedAt time.Time) {
+	pool := r.pool
+	g := pool.groups[pool.next.Add(1)%uint64(len(pool.groups))]
+	p := &pooledConn{
+		ctx:          ctx,
+		w:            w,
+		sc:           sc,
+		pc:           sc.NetConn().(*pollConn),
+		group:        g,
+		openedAt:     openedAt,
+		lastMessage:  openedAt,
+		firstMessage: true,
+	}
+	r.countActive()
+	if r.cfg.MaxMessageSize > 0 {
+		sc.SetReadLimit(r.cfg.MaxMessageSize)
+	}
+	// Nothing but the server closing, the network failing or a ping
+	// failing ends the connection, so no read deadline is needed.
+	sc.SetReadDeadline(time.Time{})
+	p.removeSoak = r.soak.add(w.id, sc)
+	p.stopShutdown = func() bool { return true }
+	p.pc.held.Store(p)
+	if !r.deliver(p) {
+		return
+	}
+
+	p.stopShutdown = context.AfterFunc(ctx, func() { r.shutdownPooled(p) })
+	g.mu.Lock()
+	g.conns[p.pc.fd] = p
+	err := g.poller.add(p.pc.fd)
+	g.mu.Unlock()
+	if err != nil {
+		sc.log.Debug("Connection pool failed to watch connection", "err", err)
+		r.endPooled(p, connDropped)
+	}
+}
+
+// detach takes p out of the group, reporting whether it was still in it.
+func (g *pollGroup) detach(p *pooledConn) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.conns[p.pc.fd] != p {
+		return false
+	}
+	g.poller.remove(p.pc.fd)
+	delete(g.conns, p.pc.fd)
+	return true
+}
+
+// servePollGroup reads g's connections as they become readable until stop is
+// closed.
+func (r *runner) servePollGroup(g *pollGroup, stop <-chan struct{}) {
+	buf := make([]byte, pollReadBuffer)
+	fds := make([]int, 0, pollEvents)
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		var err error
+		fds, err = g.poller.wait(fds[:0], pollWait)
+		if err != nil {
+			r.log.Error("Connection pool failed to wait for connections", "err", err)
+			return
+		}
+		for _, fd := range fds {
+			g.mu.Lock()
+			p := g.conns[fd]
+			g.mu.Unlock()
+			if p != nil {
+				r.readPooled(p, buf)
+			}
+		}
+		r.settleGroup(g)
+	}
+}
+
+// readPooled reads what has arrived on p and passes on the messages it
+// completes.
+func (r *runner) readPooled(p *pooledConn, buf []byte) {
+	n, err := readNow(p.pc.raw, buf)
+	if err != nil {
+		log := p.sc.log
+		switch {
+		case p.ctx.Err() != nil:
+			log.Debug("Server didn't answer the close frame", "err", err, "closeTimeout", r.cfg.CloseTimeout)
+			r.countClose(false)
+			r.endPooled(p, connShutdown)
+		case errors.Is(err, net.ErrClosed):
+			// Closed elsewhere; settleGroup ends it.
+		default:
+			log.Debug("Connection closed", "err", err)
+			r.endPooled(p, connDropped)
+		}
+		return
+	}
+	if n > 0 {
+		p.pc.in = append(p.pc.in, buf[:n]...)
+		r.deliver(p)
+	}
+}
+
+// deliver splits the frames that have arrived on p and reads the messages
+// they complete. It reports false if the connection has ended.
+func (r *runner) deliver(p *pooledConn) bool {
+	if err := r.split(p); err != nil {
+		atomic.AddInt64(&r.stats.oversized, 1)
+		p.sc.log.Debug("Message exceeded the size limit, closing connection", "maxMessageSize", r.cfg.MaxMessageSize)
+		r.endPooled(p, connDropped)
+		return false
+	}
+
+	conn, log := p.sc, p.sc.log
+	for ; p.pc.messages > 0; p.pc.messages-- {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			isClose := errors.As(err, &closeErr)
+			switch {
+			case p.ctx.Err() != nil:
+				if !isClose {
+					log.Debug("Server didn't answer the close frame", "err", err, "closeTimeout", r.cfg.CloseTimeout)
+				}
+				r.countClose(isClose)
+				r.endPooled(p, connShutdown)
+				return false
+			case isClose:
+				r.recordClose(closeErr)
+				conn.closeCode = closeErr.Code
+				log.Debug("Connection closed", "err", err)
+			case errors.Is(err, websocket.ErrReadLimit):
+				atomic.AddInt64(&r.stats.oversized, 1)
+				log.Debug("Message exceeded the size limit, closing connection", "maxMessageSize", r.cfg.MaxMessageSize)
+			default:
+				log.Debug("Unhandled read error", "err", err)
+			}
+			r.endPooled(p, connDropped)
+			return false
+		}
+		r.receivedPooled(p, messageType, data)
+	}
+	return true
+}
+
+// receivedPooled counts a message read from p, like the read loop of
+// runConnection does for its connection.
+func (r *runner) receivedPooled(p *pooledConn, messageType int, data []byte) {
+	conn := p.sc
+	atomic.AddInt64(&r.stats.bytesRead, int64(len(data)))
+	conn.bytesRead += int64(len(data))
+	conn.messagesReceived.Add(1)
+
+	now := time.Now()
+	if r.measuring.Load() {
+		if p.firstMessage {
+			r.firstMessage.Record(now.Sub(p.lastMessage))
+		} else {
+			r.interarrival.Record(now.Sub(p.lastMessage))
+		}
+	}
+	p.lastMessage, p.firstMessage = now, false
+
+	if r.events != nil {
+		r.events.emit(event{Type: eventMessage, Worker: p.w.id, Connection: conn.num, Bytes: len(data)})
+	}
+	if r.expect != nil && messageType == websocket.TextMessage {
+		r.checkExpect(p.w.id, conn, data)
+	}
+	if conn.log.Enabled(p.ctx, slog.LevelDebug) {
+		switch messageType {
+		case websocket.TextMessage:
+			conn.log.Debug("Received text message", "data", string(data))
+		case websocket.BinaryMessage:
+			conn.log.Debug("Received binary message", "bytes", len(data), "hex", hexDump(data))
+		}
+	}
+}
+
+// shutdownPooled sends p's close frame once its worker's context is done and
+// has the pool wait for the answer.
+func (r *runner) shutdownPooled(p *pooledConn) {
+	log := p.sc.log
+	log.Debug("Received shutdown, closing connection")
+	sent := r.sendClose(log, p.sc.Conn)
+
+	g := p.group
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if sent {
+		p.closeBy = time.Now().Add(r.cfg.CloseTimeout)
+	}
+	g.closing = append(g.closing, p)
+}
+
+// settleGroup ends the connections of g that were closed elsewhere, and
+// those shutting down whose servers didn't answer the close frame in time.
+func (r *runner) settleGroup(g *pollGroup) {
+	now := time.Now()
+	var closed, expired []*pooledConn
+	g.mu.Lock()
+	closed, g.closed = g.closed, nil
+	waiting := g.closing[:0]
+	for _, p := range g.closing {
+		switch {
+		case p.ended.Load():
+		case p.closeBy.IsZero() || now.After(p.closeBy):
+			expired = append(expired, p)
+		default:
+			waiting = append(waiting, p)
+		}
+	}
+	clear(g.closing[len(waiting):])
+	g.closing = waiting
+	g.mu.Unlock()
+
+	for _, p := range closed {
+		if p.ctx.Err() != nil {
+			r.countClose(false)
+			r.endPooled(p, connShutdown)
+		} else {
+			r.endPooled(p, connDropped)
+		}
+	}
+	for _, p := range expired {
+		p.sc.log.Debug("Server didn't answer the close frame", "closeTimeout", r.cfg.CloseTimeout)
+		r.countClose(false)
+		r.endPooled(p, connShutdown)
+	}
+}
+
+// endPooled closes p, once, and resumes its worker.
+func (r *runner) endPooled(p *pooledConn, end connEnd) {
+	if !p.ended.CompareAndSwap(false, true) {
+		return
+	}
+	p.stopShutdown()
+	p.group.detach(p)
+	p.sc.Close()
+	p.removeSoak()
+	atomic.AddInt64(&r.stats.active, -1)
+	r.emitClosed(p.w, p.sc, end, p.openedAt)
+	go r.resumeWorker(p.ctx, p.w, end)
+}
+
+// poolDialers sets r.pool up and makes every dialer's connections ones it
+// can hold. They also share their write buffers and keep small read buffers,
+// as pooled connections are mostly idle.
+func (r *runner) poolDialers() error {
+	for _, t := range r.targets {
+		if strings.HasPrefix(t.dialURL, "wss:") {
+			return fmt.Errorf("pool size (--pool-size) can't be used with %s: TLS connections can't be read without a goroutine each", t.url)
+		}
+	}
+
+	dialers := []*websocket.Dialer{r.dialer}
+	if r.sources != nil {
+		dialers = append(dialers, r.sources.dialers...)
+	}
+	for _, t := range r.targets {
+		if t.dialer != nil {
+			dialers = append(dialers, t.dialer)
+		}
+	}
+	writeBuffers := new(sync.Pool)
+	wrapped := make(map[*websocket.Dialer]bool)
+	for _, d := range dialers {
+		if wrapped[d] {
+			continue
+		}
+		wrapped[d] = true
+		dial := d.NetDialContext
+		if dial == nil {
+			dial = connectFunc(nil, 0)
+		}
+		d.NetDialContext = pollDial(dial)
+		d.ReadBufferSize = pooledReadBuffer
+		d.WriteBufferPool = writeBuffers
+	}
+	r.pool = &connPool{}
+	return nil
+}
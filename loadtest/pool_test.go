@@ -0,0 +1,204 @@
+package loadtest
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPoolReadsMessages(t *testing.T) {
+	if !pollSupported {
+		t.Skip("the connection pool needs Linux")
+	}
+	large := strings.Repeat("x", 10000)
+	url := newWSServer(t, func(conn *websocket.Conn, _ *http.Request) {
+		conn.WriteMessage(websocket.PingMessage, []byte("hi"))
+		// Large enough to be sent in fragments.
+		w, _ := conn.NextWriter(websocket.BinaryMessage)
+		w.Write([]byte(large))
+		w.Close()
+		// A frame that arrives in two pieces.
+		frame := []byte{0x81, 0x05, 'h', 'e', 'l', 'l', 'o'}
+		conn.NetConn().Write(frame[:3])
+		time.Sleep(20 * time.Millisecond)
+		conn.NetConn().Write(frame[3:])
+		time.Sleep(20 * time.Millisecond)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "done"))
+		conn.ReadMessage()
+	})
+	cfg := testConfig(url)
+	cfg.Soak = true
+	cfg.PoolSize = 1
+	cfg.NoReconnect = true
+	cfg.Duration = 300 * time.Millisecond
+
+	summary, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int64(cfg.Concurrency)
+	if want := n * int64(len(large)+len("hello")); summary.BytesRead != want {
+		t.Errorf("bytesRead = %d, want %d", summary.BytesRead, want)
+	}
+	if summary.PingsReceived != n || summary.PongsSent != n {
+		t.Errorf("pingsReceived = %d, pongsSent = %d, want %d", summary.PingsReceived, summary.PongsSent, n)
+	}
+	if got := summary.CloseCodes["4000"]; got != n {
+		t.Errorf("closeCodes[4000] = %d, want %d", got, n)
+	}
+	if summary.ActiveAtEnd != 0 {
+		t.Errorf("activeAtEnd = %d, want 0", summary.ActiveAtEnd)
+	}
+}
+
+func TestPoolClosesCleanly(t *testing.T) {
+	if !pollSupported {
+		t.Skip("the connection pool needs Linux")
+	}
+	cfg := testConfig(newEchoServer(t))
+	cfg.Soak = true
+	cfg.PoolSize = 2
+	cfg.Concurrency = 10
+	cfg.Keepalive = 20 * time.Millisecond
+	cfg.Duration = 200 * time.Millisecond
+
+	summary, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int64(cfg.Concurrency)
+	if summary.Successful != n || summary.CleanCloses != n {
+		t.Errorf("successful = %d, cleanCloses = %d, want %d", summary.Successful, summary.CleanCloses, n)
+	}
+	if summary.PongsReceived == 0 || summary.PongsReceived != summary.PingsSent {
+		t.Errorf("pongsReceived = %d, pingsSent = %d, want all pings answered", summary.PongsReceived, summary.PingsSent)
+	}
+}
+
+// benchmarkConns is how many connections BenchmarkHoldConnections holds.
+const benchmarkConns = 2000
+
+// BenchmarkHoldConnections compares the goroutines and memory it takes to
+// hold idle connections with a goroutine reading each and with the pool:
+//
+//	go test ./loadtest -run '^$' -bench HoldConnections -benchtime 1x
+//
+// The server is in the same process, but parks its connections without a
+// goroutine or buffer each, so what is measured is the client's.
+func BenchmarkHoldConnections(b *testing.B) {
+	if !pollSupported {
+		b.Skip("the connection pool needs Linux")
+	}
+	url := newParkingServer(b)
+	for _, bc := range []struct {
+		name     string
+		poolSize int
+	}{
+		{"goroutine-per-conn", 0},
+		{"pool", 4},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			cfg := testConfig(url)
+			cfg.Soak = true
+			cfg.PoolSize = bc.poolSize
+			cfg.Concurrency = benchmarkConns
+			cfg.Rate = 10000
+			cfg.Duration = time.Second
+			// The server never answers close frames.
+			cfg.CloseTimeout = 50 * time.Millisecond
+
+			var goroutines, rss int64
+			for b.Loop() {
+				runtime.GC()
+				debug.FreeOSMemory()
+				// Measured once ramp-up is over and before shutdown.
+				g, m := heldUsage(b, cfg.Duration*3/4, func() {
+					if _, err := Run(context.Background(), cfg); err != nil {
+						b.Fatal(err)
+					}
+				})
+				goroutines, rss = max(goroutines, g), max(rss, m)
+			}
+			b.ReportMetric(float64(goroutines)/benchmarkConns, "goroutines/conn")
+			b.ReportMetric(float64(rss)/1024/benchmarkConns, "RSS-KiB/conn")
+		})
+	}
+}
+
+// heldUsage runs f and returns how many more goroutines and bytes of
+// resident memory the process uses after has passed than before f started.
+func heldUsage(b *testing.B, after time.Duration, f func()) (goroutines, rss int64) {
+	baseGoroutines, baseRSS := int64(runtime.NumGoroutine()), residentBytes(b)
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		time.Sleep(after)
+		goroutines = int64(runtime.NumGoroutine()) - baseGoroutines
+		rss = residentBytes(b) - baseRSS
+	})
+	f()
+	wg.Wait()
+	return goroutines, rss
+}
+
+// residentBytes returns the resident memory of the process.
+func residentBytes(b *testing.B) int64 {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var size, resident int64
+	if _, err := fmt.Sscan(string(statm), &size, &resident); err != nil {
+		b.Fatal(err)
+	}
+	return resident * int64(os.Getpagesize())
+}
+
+// newParkingServer starts a WebSocket server that accepts every handshake on
+// a single goroutine and then leaves the connection be until the benchmark
+// ends, and returns its ws:// URL.
+func newParkingServer(b *testing.B) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	var parked []net.Conn
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			sum := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+			fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+			parked = append(parked, conn)
+		}
+	}()
+	b.Cleanup(func() {
+		ln.Close()
+		<-done
+		for _, conn := range parked {
+			conn.Close()
+		}
+	})
+	return "ws://" + ln.Addr().String() + "/"
+}
@@ -0,0 +1,59 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// PrecheckSummary is the handshake latency of a Config.Precheck probe.
+type PrecheckSummary struct {
+	URL         string  `json:"url"`
+	HandshakeMs float64 `json:"handshakeMs"`
+}
+
+// precheck opens and cleanly closes one connection to every target, through
+// the dialer its workers will use, before any worker starts. It fails on the
+// first target that can't be reached or rejects the handshake, so a typo in
+// a URL or a server that is down costs one dial instead of a ramp-up of
+// failing workers. Probes aren't counted in the run's stats.
+func (r *runner) precheck(ctx context.Context) error {
+	for _, t := range r.targets {
+		dialer := r.dialer
+		switch {
+		case t.dialer != nil:
+			dialer = t.dialer
+		case r.sources != nil:
+			dialer = r.sources.next()
+		}
+
+		start := time.Now()
+		conn, resp, err := dialer.DialContext(ctx, t.dialURL, t.header)
+		if err != nil {
+			msg := fmt.Sprintf("%s: %s", t.url, err)
+			if resp == nil {
+				msg += fmt.Sprintf(" (failure category %s)", classifyDialError(err, resp))
+			} else {
+				body, _ := io.ReadAll(io.LimitReader(resp.Body, maxLoggedBody+1))
+				text := string(body)
+				if len(body) > maxLoggedBody {
+					text = string(body[:maxLoggedBody]) + "..."
+				}
+				msg += fmt.Sprintf(", server answered %s", resp.Status)
+				if text = strings.TrimSpace(text); text != "" {
+					msg += ": " + text
+				}
+			}
+			return fmt.Errorf("precheck (--precheck) failed for %s", msg)
+		}
+		handshake := time.Since(start)
+		r.closeHandshake(r.log, conn)
+		conn.Close()
+
+		r.prechecks = append(r.prechecks, PrecheckSummary{URL: t.url, HandshakeMs: durationMs(handshake)})
+		r.log.Info("Precheck passed", "url", t.url, "handshake", roundLatency(handshake))
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package loadtest
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// parseProxy validates a proxy URL for the dialer. Gorilla tunnels through
+// HTTP proxies with CONNECT and also speaks SOCKS5; it cannot talk TLS to
+// the proxy itself.
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, must be http or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	return u, nil
+}
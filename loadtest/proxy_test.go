@@ -0,0 +1,107 @@
+package loadtest
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyConnect(t *testing.T) {
+	target := newEchoServer(t)
+	requests := make(chan *http.Request, 2)
+	proxy := newConnectProxy(t, requests)
+
+	cfg := testConfig(target)
+	cfg.Proxy = "http://user:secret@" + proxy
+	cfg.Duration = 100 * time.Millisecond
+	summary, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Successful != int64(cfg.Concurrency) {
+		t.Errorf("successful = %d, want %d", summary.Successful, cfg.Concurrency)
+	}
+
+	req := <-requests
+	if want := strings.TrimPrefix(target, "ws://"); req.Method != http.MethodConnect || req.Host != want {
+		t.Errorf("proxy got %s %s, want CONNECT %s", req.Method, req.Host, want)
+	}
+	if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret")); req.Header.Get("Proxy-Authorization") != want {
+		t.Errorf("Proxy-Authorization = %q, want %q", req.Header.Get("Proxy-Authorization"), want)
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{raw: "http://proxy.internal:3128"},
+		{raw: "socks5://127.0.0.1:1080"},
+		{raw: "https://proxy.internal:3128", wantErr: true},
+		{raw: "proxy.internal:3128", wantErr: true},
+		{raw: "http://", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := parseProxy(tt.raw); (err != nil) != tt.wantErr {
+			t.Errorf("parseProxy(%q) error = %v, want error: %v", tt.raw, err, tt.wantErr)
+		}
+	}
+}
+
+// newConnectProxy starts an HTTP proxy that only understands CONNECT, sends
+// every request it gets to requests and tunnels it through. It returns the
+// proxy's address.
+func newConnectProxy(t *testing.T, requests chan<- *http.Request) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			client, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go tunnel(client, requests)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func tunnel(client net.Conn, requests chan<- *http.Request) {
+	defer client.Close()
+	br := bufio.NewReader(client)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	select {
+	case requests <- req:
+	default:
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(client, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+	server, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(client, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer server.Close()
+	io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go func() {
+		io.Copy(server, br)
+		server.Close()
+	}()
+	io.Copy(client, server)
+}
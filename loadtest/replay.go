@@ -0,0 +1,193 @@
+package loadtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A Replay is a captured sequence of client messages that every connection
+// sends again with the same relative timing, in place of the plain send
+// loop.
+type Replay struct {
+	Messages []ReplayMessage
+}
+
+// A ReplayMessage is one message of a Replay. Offset is when it is sent,
+// counted from the start of the sequence.
+type ReplayMessage struct {
+	Offset time.Duration
+	Data   []byte
+	Binary bool
+}
+
+// replayLine is one line of a replay file.
+type replayLine struct {
+	// Time is an RFC 3339 timestamp or a number of milliseconds; only the
+	// differences between lines matter.
+	Time   json.RawMessage `json:"time"`
+	Text   *string         `json:"text"`
+	Binary []byte          `json:"binary"`
+}
+
+// pcapMagic holds the first bytes of pcap and pcapng captures, in both byte
+// orders, so they can be told apart from a message file.
+var pcapMagic = [][]byte{
+	{0xd4, 0xc3, 0xb2, 0xa1},
+	{0xa1, 0xb2, 0xc3, 0xd4},
+	{0x4d, 0x3c, 0xb2, 0xa1},
+	{0xa1, 0xb2, 0x3c, 0x4d},
+	{0x0a, 0x0d, 0x0d, 0x0a},
+}
+
+// LoadReplay reads a replay file of newline-delimited JSON objects, one per
+// message, each with a "time" and either a "text" message or a base64
+// "binary" one. Times must not go backwards. Blank lines are skipped.
+func LoadReplay(path string) (*Replay, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, magic := range pcapMagic {
+		if bytes.HasPrefix(data, magic) {
+			return nil, fmt.Errorf("%s is a packet capture, which can't be replayed directly; export its WebSocket messages as JSON lines first", path)
+		}
+	}
+
+	var replay Replay
+	var first, prev time.Time
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	// Allow for a payload of the maximum size encoded as base64.
+	sc.Buffer(nil, 2*maxPayloadSize)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		msg, at, err := parseReplayLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if first.IsZero() {
+			first, prev = at, at
+		}
+		if at.Before(prev) {
+			return nil, fmt.Errorf("%s:%d: time goes backwards", path, n)
+		}
+		prev = at
+		msg.Offset = at.Sub(first)
+		replay.Messages = append(replay.Messages, msg)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(replay.Messages) == 0 {
+		return nil, fmt.Errorf("%s holds no messages", path)
+	}
+	return &replay, nil
+}
+
+// parseReplayLine parses one line of a replay file into its message and the
+// time it was captured at.
+func parseReplayLine(line []byte) (ReplayMessage, time.Time, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+	var l replayLine
+	if err := dec.Decode(&l); err != nil {
+		return ReplayMessage{}, time.Time{}, err
+	}
+
+	var msg ReplayMessage
+	switch {
+	case l.Text != nil && l.Binary != nil:
+		return msg, time.Time{}, errors.New(`a line must have either "text" or "binary", not both`)
+	case l.Text != nil:
+		msg.Data = []byte(*l.Text)
+	case l.Binary != nil:
+		msg.Data, msg.Binary = l.Binary, true
+	default:
+		return msg, time.Time{}, errors.New(`a line must have "text" or "binary"`)
+	}
+
+	at, err := parseReplayTime(l.Time)
+	if err != nil {
+		return msg, time.Time{}, err
+	}
+	return msg, at, nil
+}
+
+// parseReplayTime parses a "time", an RFC 3339 string or milliseconds.
+func parseReplayTime(raw json.RawMessage) (time.Time, error) {
+	if len(raw) == 0 {
+		return time.Time{}, errors.New(`missing "time"`)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		at, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf(`"time" %q is not an RFC 3339 timestamp`, s)
+		}
+		return at, nil
+	}
+	var ms float64
+	if err := json.Unmarshal(raw, &ms); err != nil || ms < 0 {
+		return time.Time{}, fmt.Errorf(`"time" %s is neither an RFC 3339 timestamp nor a number of milliseconds`, raw)
+	}
+	// Any epoch will do as long as every line uses the same one.
+	return time.UnixMilli(0).Add(time.Duration(ms * float64(time.Millisecond))), nil
+}
+
+// runReplay sends the Config.Replay messages on one connection at their
+// offsets from when it started, and over again from the first message once
+// the last has gone out if Config.ReplayLoop is set. A failed write closes
+// the connection so the read loop reconnects; after the last pass the
+// connection stays open and keeps reading.
+func (r *runner) runReplay(ctx context.Context, conn *safeConn, connDone <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	for {
+		start := time.Now()
+		for _, msg := range r.cfg.Replay.Messages {
+			// Waiting for each offset from the start, not for the gap
+			// since the previous message, keeps slow writes from
+			// stretching the sequence.
+			if wait := time.Until(start.Add(msg.Offset)); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-timer.C:
+				case <-connDone:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+			if r.sendLimiter != nil && !r.waitSendToken(ctx, connDone) {
+				return
+			}
+			messageType := websocket.TextMessage
+			if msg.Binary {
+				messageType = websocket.BinaryMessage
+			}
+			if err := conn.WriteMessage(messageType, msg.Data); err != nil {
+				conn.log.Debug("Send failed", "err", err)
+				conn.Close()
+				return
+			}
+			r.countSent(conn, len(msg.Data))
+		}
+		atomic.AddInt64(&r.stats.replaysCompleted, 1)
+		if !r.cfg.ReplayLoop {
+			return
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package loadtest
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportSample is one stats interval of the HTML report's time series.
+type reportSample struct {
+	Elapsed time.Duration
+	Snap    statsSnapshot
+	Rates   statsRates
+	// Handshake and Echo are the mean latencies of the samples recorded
+	// during the interval.
+	Handshake time.Duration
+	Echo      time.Duration
+}
+
+// The plot area inside the 720x220 SVG that reportTemplate draws, in SVG
+// user units.
+const (
+	chartPadLeft = 70
+	chartPadTop  = 10
+	chartPlotW   = 640
+	chartPlotH   = 185
+)
+
+type reportChart struct {
+	Title string
+	Max   string
+	End   string
+	Lines []reportLine
+}
+
+type reportLine struct {
+	Name   string
+	Color  string
+	Points string
+}
+
+type reportSeries struct {
+	name   string
+	color  string
+	values []float64
+}
+
+// newReportChart scales each series to the plot area. format renders the
+// y-axis maximum.
+func newReportChart(title string, samples []reportSample, format func(float64) string, series ...reportSeries) reportChart {
+	peak := 0.0
+	for _, s := range series {
+		for _, v := range s.values {
+			peak = max(peak, v)
+		}
+	}
+	end := samples[len(samples)-1].Elapsed
+	c := reportChart{Title: title, Max: format(peak), End: end.Round(time.Second).String()}
+	for _, s := range series {
+		var pts strings.Builder
+		for i, v := range s.values {
+			x := chartPadLeft + float64(chartPlotW)*float64(samples[i].Elapsed)/float64(max(end, 1))
+			y := float64(chartPadTop + chartPlotH)
+			if peak > 0 {
+				y -= float64(chartPlotH) * v / peak
+			}
+			fmt.Fprintf(&pts, "%.1f,%.1f ", x, y)
+		}
+		c.Lines = append(c.Lines, reportLine{Name: s.name, Color: s.color, Points: pts.String()})
+	}
+	return c
+}
+
+type reportRow struct {
+	Name, Value string
+}
+
+// writeReport renders the summary and the collected samples as a
+// self-contained HTML page with inline SVG charts, and closes f.
+func (r *runner) writeReport(f *outputFile, summary Summary) (err error) {
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	data := struct {
+		Title   string
+		Started string
+		Rows    []reportRow
+		Charts  []reportChart
+	}{
+		Title:   "go-socket-storm report",
+		Started: r.samples[0].Snap.Time.Format(time.RFC1123),
+	}
+
+	for _, t := range r.targets {
+		data.Rows = append(data.Rows, reportRow{"Target", t.url})
+	}
+	count := func(name string, v int64) {
+		data.Rows = append(data.Rows, reportRow{name, strconv.FormatInt(v, 10)})
+	}
+	data.Rows = append(data.Rows, reportRow{"Duration", time.Duration(summary.DurationMs * float64(time.Millisecond)).Round(time.Millisecond).String()})
+	count("Connection attempts", summary.Attempts)
+	count("Successful connections", summary.Successful)
+	count("Failed connections", summary.Failed)
+	for _, k := range sortedKeys(summary.FailureCategories) {
+		count("Failed: "+k, summary.FailureCategories[k])
+	}
+	count("Reconnects", summary.Reconnects)
+	count("Active at end", summary.ActiveAtEnd)
+	count("Peak active", summary.PeakActive)
+	count("Clean closes", summary.CleanCloses)
+	count("Abrupt closes", summary.AbruptCloses)
+	count("Bytes read", summary.BytesRead)
+	if r.cfg.sendsMessages() {
+		count("Messages sent", summary.MessagesSent)
+		count("Bytes written", summary.BytesWritten)
+	}
+	if r.cfg.Replay != nil {
+		count("Replays completed", summary.ReplaysCompleted)
+	}
+	if r.cfg.BackpressureGap > 0 {
+		count("Backpressured connections", summary.BackpressuredConnections)
+	}
+	if summary.TargetRPS > 0 {
+		data.Rows = append(data.Rows, reportRow{"Achieved / target RPS", fmt.Sprintf("%.1f / %d", summary.AchievedRPS, summary.TargetRPS)})
+	}
+	if summary.HandshakeLatency != nil {
+		data.Rows = append(data.Rows, reportRow{"Handshake latency", latencyDistribution(*summary.HandshakeLatency).String()})
+	}
+	if summary.EchoLatency != nil {
+		data.Rows = append(data.Rows, reportRow{"Echo latency", latencyDistribution(*summary.EchoLatency).String()})
+	}
+	if summary.ResponseLatency != nil {
+		count("Response timeouts", summary.ResponseTimeouts)
+		data.Rows = append(data.Rows, reportRow{"Response latency", latencyDistribution(*summary.ResponseLatency).String()})
+	}
+
+	if len(r.samples) > 1 {
+		data.Charts = r.reportCharts()
+	}
+	return reportTemplate.Execute(f, data)
+}
+
+func (r *runner) reportCharts() []reportChart {
+	samples := r.samples
+	series := func(name, color string, v func(reportSample) float64) reportSeries {
+		s := reportSeries{name: name, color: color, values: make([]float64, len(samples))}
+		for i, sample := range samples {
+			s.values[i] = v(sample)
+		}
+		return s
+	}
+	count := func(v float64) string { return strconv.FormatFloat(v, 'f', 0, 64) }
+	rate := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) + "/s" }
+	latency := func(v float64) string { return roundLatency(time.Duration(v)).String() }
+
+	charts := []reportChart{
+		newReportChart("Connections", samples, count,
+			series("active", "#2563eb", func(s reportSample) float64 { return float64(s.Snap.Active) }),
+			series("failed (total)", "#dc2626", func(s reportSample) float64 { return float64(s.Snap.Failed) })),
+		newReportChart("Connections opened per second", samples, rate,
+			series("opened", "#2563eb", func(s reportSample) float64 { return s.Rates.ConnsPerSec })),
+		newReportChart("Bytes read per second", samples, func(v float64) string { return formatBytes(v) + "/s" },
+			series("read", "#16a34a", func(s reportSample) float64 { return s.Rates.BytesReadPerSec })),
+	}
+	if r.cfg.sendsMessages() {
+		charts = append(charts, newReportChart("Messages sent per second", samples, rate,
+			series("sent", "#9333ea", func(s reportSample) float64 { return s.Rates.MessagesPerSec })))
+	}
+	latencies := []reportSeries{
+		series("handshake", "#ea580c", func(s reportSample) float64 { return float64(s.Handshake) }),
+	}
+	if r.cfg.Echo {
+		latencies = append(latencies,
+			series("echo round trip", "#0891b2", func(s reportSample) float64 { return float64(s.Echo) }))
+	}
+	return append(charts, newReportChart("Mean latency per interval", samples, latency, latencies...))
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 760px; color: #111; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td { padding: 2px 12px 2px 0; vertical-align: top; }
+td:first-child { color: #555; }
+h2 { font-size: 1.05em; margin: 1.5em 0 0.3em; }
+svg { background: #fafafa; border: 1px solid #ddd; }
+svg text { font-size: 11px; fill: #555; }
+.legend span { margin-right: 1em; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Started {{.Started}}</p>
+<table>
+{{range .Rows}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{range .Charts}}
+<h2>{{.Title}}</h2>
+<div class="legend">{{range .Lines}}<span style="color: {{.Color}}">&#9632; {{.Name}}</span>{{end}}</div>
+<svg viewBox="0 0 720 220" width="720" height="220" role="img">
+<line x1="70" y1="195" x2="710" y2="195" stroke="#999"/>
+<line x1="70" y1="10" x2="70" y2="195" stroke="#999"/>
+<text x="64" y="16" text-anchor="end">{{.Max}}</text>
+<text x="64" y="195" text-anchor="end">0</text>
+<text x="70" y="212">0s</text>
+<text x="710" y="212" text-anchor="end">{{.End}}</text>
+{{range .Lines}}<polyline fill="none" stroke="{{.Color}}" stroke-width="1.5" points="{{.Points}}"/>
+{{end}}</svg>
+{{else}}
+<p>Not enough stats intervals were recorded to draw charts.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,49 @@
+package loadtest
+
+import (
+	"context"
+	"time"
+)
+
+// rpsTick is how often the Config.TargetRPS scheduler hands out the permits
+// that have come due.
+const rpsTick = 10 * time.Millisecond
+
+// newRPSPermits returns the channel the Config.TargetRPS scheduler hands
+// send permits out on. It holds one tick's worth, so a tick's permits can
+// all wait for a connection to take them.
+func newRPSPermits(target int) chan time.Time {
+	perTick := (target*int(rpsTick/time.Millisecond) + 999) / 1000
+	return make(chan time.Time, max(perTick, 1))
+}
+
+// scheduleRPS hands out send permits at Config.TargetRPS until ctx is done.
+// Every connection sending takes its next message's permit from the same
+// channel, so the permits go to whichever connections are ready, however
+// many are open. A permit arriving while a tick's worth are still waiting
+// is dropped rather than saved up, so a server that falls behind lowers the
+// achieved rate instead of getting a burst once it recovers.
+func (r *runner) scheduleRPS(ctx context.Context) {
+	ticker := time.NewTicker(rpsTick)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var issued int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// Counting from the start rather than per tick keeps late
+			// ticks from losing permits.
+			due := int64(now.Sub(start).Seconds()*float64(r.cfg.TargetRPS)) - issued
+			for ; due > 0; due-- {
+				issued++
+				select {
+				case r.rpsPermits <- now:
+				default:
+				}
+			}
+		}
+	}
+}
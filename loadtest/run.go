@@ -0,0 +1,1071 @@
+// Package loadtest opens and holds large numbers of WebSocket connections
+// against one or more servers and reports what happened.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// counters are the run-wide totals. They are updated with sync/atomic.
+type counters struct {
+	// attempts counts every dial, reconnects included.
+	attempts      int64
+	successful    int64
+	failed        int64
+	active        int64
+	bytesRead     int64
+	bytesWritten  int64
+	messagesSent  int64
+	reconnects    int64
+	pingsSent     int64
+	pongsReceived int64
+	// pingsReceived counts the server's pings and pongsSent the pongs
+	// answering them.
+	pingsReceived int64
+	pongsSent     int64
+	churned       int64
+	// cleanCloses and abruptCloses count the connections the client closed
+	// whose server did and didn't answer the close frame in time.
+	cleanCloses  int64
+	abruptCloses int64
+	// heartbeatsSent counts Config.HeartbeatMessage sends.
+	heartbeatsSent int64
+	// replaysCompleted counts the passes through Config.Replay that sent
+	// every message.
+	replaysCompleted int64
+	// assertionFailures counts received text messages that didn't match
+	// Config.Expect.
+	assertionFailures int64
+	// oversized counts connections closed for exceeding
+	// Config.MaxMessageSize.
+	oversized int64
+	// stalled counts connections closed by Config.IdleTimeout.
+	stalled int64
+	// responses and responseTimeouts count, with Config.CorrelationField,
+	// the requests answered in time and those that weren't.
+	responses        int64
+	responseTimeouts int64
+	// compressed counts connections that negotiated permessage-deflate.
+	compressed int64
+	// backpressured counts connections flagged by Config.BackpressureGap.
+	backpressured int64
+}
+
+// runner holds everything a single Run needs, so several runs can coexist in
+// one process.
+type runner struct {
+	cfg     Config
+	targets []*target
+	header  http.Header
+	dialer  *websocket.Dialer
+	proxy   *url.URL
+	log     *slog.Logger
+	// samples are the report's time series, appended by printStats and
+	// read once it has returned.
+	samples []reportSample
+	// dash, if set, is the live dashboard; log then routes through it.
+	dash *dashboard
+	// sources, if set, replaces dialer with one bound to a source address
+	// per worker.
+	sources *addrPool
+	// queryKeys are the Config.Query keys, in order, for logging.
+	queryKeys []string
+	// cookies counts the cookies loaded into the dialer's jar.
+	cookies int
+	// loginRegex is Config.LoginTokenRegex compiled.
+	loginRegex *regexp.Regexp
+	// payload is what every connection sends, shared read-only. When
+	// payloadTmpl is set it is rendered per message instead.
+	payload     []byte
+	payloadTmpl *template.Template
+	// heartbeat is Config.HeartbeatMessage, or heartbeatTmpl if it is a
+	// template.
+	heartbeat     []byte
+	heartbeatTmpl *template.Template
+	// seed is Config.Seed, or the time-based seed picked in its place.
+	seed uint64
+	// expect is Config.Expect compiled.
+	expect *regexp.Regexp
+	// scenario is Config.Scenario compiled, with per-step counters.
+	scenario []*scenarioStep
+	// sendRate is the current Config.SendRate, which a reload may change.
+	sendRate atomic.Int64
+	// live holds the settings in effect that a reload may change. It is
+	// only used by the run loop.
+	live LiveConfig
+	// statsIntervals passes reloaded stats intervals to printStats.
+	statsIntervals chan time.Duration
+	// sendLimiter, if set, is the Config.TotalSendRate bucket every send
+	// takes a token from.
+	sendLimiter *rate.Limiter
+	// rpsPermits, if set, carries the Config.TargetRPS send permits.
+	rpsPermits chan time.Time
+	// connLimiter is the Config.Rate bucket that paces new workers and,
+	// with Config.ConnectOnly, every redial.
+	connLimiter *rate.Limiter
+	// events, if set, is the Config.EventsPath stream.
+	events *eventLog
+
+	stats       counters
+	echoLatency LatencyRecorder
+	handshakes  LatencyRecorder
+	// firstMessage is the time from handshake to the first message on each
+	// connection, and interarrival the gaps between messages after it.
+	firstMessage LatencyRecorder
+	interarrival LatencyRecorder
+	// responseLatency is the time from each correlated request to its
+	// response.
+	responseLatency LatencyRecorder
+	subprotocols    tally
+	tlsVersions     tally
+	failures        tally
+	statusCodes     tally
+	closeCodes      tally
+	// closeReasons maps each close code to the reason text it first came
+	// with.
+	closeReasons sync.Map
+	// serverPingInterval is the time between consecutive server pings on
+	// each connection.
+	serverPingInterval LatencyRecorder
+
+	// measuring is set once the warmup is over; latencies are only
+	// recorded while it is set. warmupEnd holds the counters at that moment.
+	measuring         atomic.Bool
+	warmupEnd         statsSnapshot
+	warmupFailures    map[string]int64
+	warmupStatusCodes map[string]int64
+	warmupCloseCodes  map[string]int64
+
+	// prechecks are the results of Config.Precheck, one per target.
+	prechecks []PrecheckSummary
+
+	// peakActive is the highest number of connections open at once. It is
+	// raised with a compare-and-swap wherever stats.active is incremented.
+	peakActive atomic.Int64
+	// soak, if set, pings every connection in place of their own ping
+	// loops.
+	soak *soakPinger
+	// pool, if set, reads every connection in place of their own read
+	// loops.
+	pool *connPool
+
+	// fullAt is when the number of open connections first reached
+	// Config.Concurrency, in Unix nanoseconds, or zero until then, and
+	// attemptsToTarget the dials made by that time.
+	fullAt           atomic.Int64
+	attemptsToTarget atomic.Int64
+	// retryDeadline is when Config.RetryUntilTarget stops replacing failed
+	// dials. It is set before the first worker starts.
+	retryDeadline time.Time
+}
+
+// errDurationReached is the cancellation cause when Config.Duration elapses.
+var errDurationReached = errors.New("test duration reached")
+
+// Run executes a load test and blocks until it finishes: when cfg.Duration
+// elapses or ctx is cancelled, whichever comes first, after which every
+// connection is closed. An error is returned only if the configuration is
+// invalid or the run could not be started.
+func Run(ctx context.Context, cfg Config) (Summary, error) {
+	r, err := newRunner(cfg)
+	if err != nil {
+		return Summary{}, err
+	}
+	return r.run(ctx)
+}
+
+// Check validates cfg and resolves it the way Run would, loading certificate
+// and payload files and probing source addresses, then logs the resulting
+// configuration. No connections are opened.
+func Check(cfg Config) error {
+	r, err := newRunner(cfg)
+	if err != nil {
+		return err
+	}
+	r.logConfig("Resolved configuration")
+	return nil
+}
+
+// newRunner validates cfg and resolves it into ready-to-use state.
+func newRunner(cfg Config) (*runner, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	r := &runner{
+		cfg:  cfg,
+		log:  cfg.Logger,
+		seed: cfg.Seed,
+	}
+	if r.seed == 0 {
+		r.seed = uint64(time.Now().UnixNano())
+	}
+	if r.log == nil {
+		r.log = slog.Default()
+	}
+	if cfg.Dashboard != nil {
+		r.dash = &dashboard{w: cfg.Dashboard, color: cfg.Color}
+		r.log = slog.New(&dashboardHandler{next: r.log.Handler(), dash: r.dash})
+	}
+
+	for _, entry := range cfg.URLs {
+		t, err := parseTarget(entry)
+		if err != nil {
+			return nil, err
+		}
+		r.targets = append(r.targets, t)
+	}
+
+	if len(cfg.Query) > 0 {
+		q, keys, err := parseQuery(cfg.Query)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range r.targets {
+			if err := t.addQuery(q, keys); err != nil {
+				return nil, fmt.Errorf("%s: %w", t.url, err)
+			}
+		}
+		r.queryKeys = keys
+	}
+
+	var err error
+	if cfg.Soak {
+		r.cfg.PingInterval, r.cfg.ReadTimeout = 0, 0
+		r.soak = &soakPinger{}
+	}
+	if cfg.ConnectOnly {
+		// Connections don't live long enough to be pinged.
+		r.cfg.PingInterval, r.cfg.Keepalive = 0, 0
+	}
+
+	r.payload = []byte(cfg.Send)
+	r.live = LiveConfig{StatsInterval: cfg.StatsInterval, SendRate: cfg.SendRate, TotalSendRate: cfg.TotalSendRate}
+	r.sendRate.Store(int64(cfg.SendRate))
+	r.statsIntervals = make(chan time.Duration, 1)
+	if cfg.TotalSendRate > 0 {
+		r.sendLimiter = rate.NewLimiter(rate.Limit(cfg.TotalSendRate), 1)
+	}
+	if cfg.TargetRPS > 0 {
+		r.rpsPermits = newRPSPermits(cfg.TargetRPS)
+	}
+	r.payloadTmpl, err = parsePayloadTemplate(cfg.Send)
+	if err != nil {
+		return nil, fmt.Errorf("invalid send template (--send): %w", err)
+	}
+	r.heartbeat = []byte(cfg.HeartbeatMessage)
+	r.heartbeatTmpl, err = parsePayloadTemplate(cfg.HeartbeatMessage)
+	if err != nil {
+		return nil, fmt.Errorf("invalid heartbeat template (--heartbeat-message): %w", err)
+	}
+	if cfg.PayloadFile != "" {
+		r.payload, err = loadPayload(cfg.PayloadFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload file (--payload-file): %w", err)
+		}
+	}
+	if cfg.CorrelationField != "" {
+		payload := r.payload
+		if r.payloadTmpl != nil {
+			payload, _ = renderPayload(r.payloadTmpl, new(bytes.Buffer), payloadData{})
+		}
+		if !isJSONObject(payload) {
+			return nil, errors.New("request correlation (--correlate) requires the payload to be a JSON object")
+		}
+	}
+
+	if cfg.Expect != "" {
+		r.expect, err = regexp.Compile(cfg.Expect)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expect pattern (--expect): %w", err)
+		}
+	}
+	if cfg.Scenario != nil {
+		r.scenario, err = compileScenario(cfg.Scenario)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scenario (--scenario): %w", err)
+		}
+	}
+
+	r.header, err = parseHeaders(cfg.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header (-H): %w", err)
+	}
+	if err := applyAuth(r.header, cfg.Bearer, cfg.BasicAuth); err != nil {
+		return nil, fmt.Errorf("invalid authentication flags: %w", err)
+	}
+	if cfg.LoginURL != "" {
+		if r.header.Get("Authorization") != "" {
+			return nil, errors.New("invalid authentication flags: --login-url conflicts with -H Authorization")
+		}
+		if cfg.LoginTokenRegex != "" {
+			r.loginRegex, err = compileLoginRegex(cfg.LoginTokenRegex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid login token pattern (--login-token-regex): %w", err)
+			}
+		}
+	}
+	if err := applyOrigin(r.header, cfg.Origin, r.targets); err != nil {
+		return nil, fmt.Errorf("invalid origin: %w", err)
+	}
+
+	tlsConfig, err := buildTLSConfig(tlsOptions{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		CACert:             cfg.CACert,
+		ClientCert:         cfg.ClientCert,
+		ClientKey:          cfg.ClientKey,
+		ServerName:         cfg.SNI,
+		MinVersion:         cfg.TLSMinVersion,
+		MaxVersion:         cfg.TLSMaxVersion,
+		CipherSuites:       cfg.CipherSuites,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	// Every worker shares this dialer. HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// are honored unless an explicit proxy replaces them.
+	r.dialer = &websocket.Dialer{
+		Proxy:             http.ProxyFromEnvironment,
+		HandshakeTimeout:  cfg.HandshakeTimeout,
+		TLSClientConfig:   tlsConfig,
+		Subprotocols:      cfg.Subprotocols,
+		EnableCompression: cfg.Compression,
+	}
+	if cfg.ConnectTimeout > 0 {
+		r.dialer.NetDialContext = connectFunc(nil, cfg.ConnectTimeout)
+	}
+	if len(cfg.Cookies) > 0 || cfg.CookieFile != "" {
+		// The dialer's own Cookie header would replace the jar's.
+		if r.header.Get("Cookie") != "" {
+			return nil, errors.New("-cookie and -cookie-file conflict with -H Cookie")
+		}
+		r.dialer.Jar, r.cookies, err = newCookieJar(cfg.Cookies, cfg.CookieFile, r.targets)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Proxy != "" {
+		proxyURL, err := parseProxy(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy (--proxy): %w", err)
+		}
+		r.dialer.Proxy = http.ProxyURL(proxyURL)
+		r.proxy = proxyURL
+	}
+
+	// Source addresses go last: each one gets a copy of the dialer
+	// configured above.
+	if len(cfg.LocalAddrs) > 0 {
+		var addrs []*net.TCPAddr
+		for _, s := range cfg.LocalAddrs {
+			addr, err := parseLocalAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid local address (--local-addr): %w", err)
+			}
+			addrs = append(addrs, addr)
+		}
+		r.sources = newAddrPool(r.dialer, addrs, cfg.ConnectTimeout)
+	}
+	for _, t := range r.targets {
+		if t.socket != "" {
+			t.dialer = unixDialer(r.dialer, t.socket, cfg.ConnectTimeout)
+		}
+	}
+	if cfg.PoolSize > 0 {
+		if err := r.poolDialers(); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (r *runner) run(parent context.Context) (Summary, error) {
+	cfg := r.cfg
+
+	// ctx is done once the run should stop, whatever the reason. Every
+	// goroutine started below watches it.
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	if cfg.Duration > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, cfg.Duration, errDurationReached)
+		defer cancelTimeout()
+	}
+	var hardDeadline time.Time
+	if cfg.MaxRuntime > 0 {
+		hardDeadline = time.Now().Add(cfg.MaxRuntime)
+		var cancelMax context.CancelFunc
+		ctx, cancelMax = context.WithDeadlineCause(ctx, hardDeadline, errMaxRuntime)
+		defer cancelMax()
+	}
+
+	r.logConfig("Starting WebSocket load test")
+
+	if cfg.LoginURL != "" {
+		token, err := r.login(ctx)
+		if err != nil {
+			return Summary{}, fmt.Errorf("login (--login-url) failed: %w", err)
+		}
+		// Targets with an Origin of their own hold a copy of the header.
+		r.header.Set("Authorization", "Bearer "+token)
+		for _, t := range r.targets {
+			t.header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	if cfg.Precheck {
+		if err := r.precheck(ctx); err != nil {
+			return Summary{}, err
+		}
+	}
+
+	if r.pool != nil {
+		if err := r.startPool(); err != nil {
+			return Summary{}, fmt.Errorf("failed to start the connection pool: %w", err)
+		}
+		// Connections still open after the workers were waited for are
+		// left to the process exiting, as they would be without the pool.
+		defer r.stopPool()
+	}
+
+	var err error
+	var csvOut *csvStats
+	if cfg.CSVPath != "" {
+		csvOut, err = openCSVStats(cfg.CSVPath, cfg.sendsMessages(), cfg.CompressOutput)
+		if err != nil {
+			return Summary{}, fmt.Errorf("failed to open CSV stats file: %w", err)
+		}
+	}
+
+	// The report is written at the end, but its file is created now so a
+	// bad path is reported before the test starts.
+	var reportOut *outputFile
+	if cfg.ReportPath != "" {
+		reportOut, err = createOutput(cfg.ReportPath, cfg.CompressOutput)
+		if err != nil {
+			if csvOut != nil {
+				csvOut.Close()
+			}
+			return Summary{}, fmt.Errorf("failed to create HTML report: %w", err)
+		}
+	}
+
+	if cfg.EventsPath != "" {
+		r.events, err = openEventLog(cfg.EventsPath, cfg.CompressOutput)
+		if err != nil {
+			if csvOut != nil {
+				csvOut.Close()
+			}
+			if reportOut != nil {
+				reportOut.Close()
+			}
+			return Summary{}, fmt.Errorf("failed to create events file: %w", err)
+		}
+	}
+
+	var stopMetrics func()
+	if cfg.MetricsAddr != "" {
+		stopMetrics, err = r.serveMetrics(cfg.MetricsAddr)
+		if err != nil {
+			if csvOut != nil {
+				csvOut.Close()
+			}
+			if reportOut != nil {
+				reportOut.Close()
+			}
+			if r.events != nil {
+				r.events.Close()
+			}
+			return Summary{}, fmt.Errorf("failed to start metrics server: %w", err)
+		}
+		r.log.Info("Serving Prometheus metrics", "url", "http://"+cfg.MetricsAddr+"/metrics")
+	}
+
+	if cfg.MaxMessages > 0 || cfg.MaxBytes > 0 || cfg.AbortErrorRate > 0 {
+		go r.watchLimits(ctx, cancel)
+	}
+	if r.soak != nil && cfg.Keepalive > 0 {
+		go r.runSoakPinger(ctx, cfg.Keepalive)
+	}
+
+	if r.rpsPermits != nil {
+		go r.scheduleRPS(ctx)
+	}
+
+	statsDone := make(chan struct{})
+	go func() {
+		defer close(statsDone)
+		r.printStats(ctx, cfg.StatsInterval, csvOut)
+	}()
+
+	// stopDash takes the dashboard down and waits for its last frame.
+	var stopDash func()
+	if r.dash != nil {
+		stop, done := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(done)
+			r.runDashboard(ctx, stop, time.Now())
+		}()
+		stopDash = func() {
+			close(stop)
+			<-done
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	// A token bucket paces new connections at cfg.Rate. Every tick starts as
+	// many as it allows and checks the pattern target again, so connections
+	// are closed without delay.
+	tick, burst := rampTick(cfg.Rate)
+	limiter := rate.NewLimiter(rate.Limit(cfg.Rate), burst)
+	r.connLimiter = limiter
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	var adapt <-chan time.Time
+	var controller *rateController
+	if cfg.AdaptiveRate {
+		controller = newRateController(limiter, cfg.Rate, cfg.AdaptiveThreshold)
+		adaptTicker := time.NewTicker(adaptInterval)
+		defer adaptTicker.Stop()
+		adapt = adaptTicker.C
+	}
+
+	pattern := cfg.pattern()
+	var pick *rand.Rand
+	if cfg.URLSelect == selectRandom {
+		// Worker ids start at 1, so stream 0 of the seed is free for this.
+		pick = rand.New(rand.NewPCG(r.seed, 0))
+	}
+	pool := newTargetPool(r.targets, pick)
+	// workers holds a stop function per running worker, newest last.
+	var workers []context.CancelFunc
+	var workerID uint64
+	rampedUp := false
+	paused := false
+	// limited is set while GoroutineLimit holds the ramp-up.
+	limited := false
+	var warmupDone <-chan time.Time
+	startTime := time.Now()
+	measureStart := startTime
+	retryWarned := false
+	if cfg.RetryUntilTarget > 0 {
+		r.retryDeadline = startTime.Add(cfg.RetryUntilTarget)
+	}
+	if cfg.Warmup <= 0 {
+		r.measuring.Store(true)
+	}
+
+	// Workers normally stop with ctx. With a ramp-down rate their contexts
+	// outlive it instead, so they can be stopped a few at a time.
+	workerParent := ctx
+	if cfg.RampDownRate > 0 {
+		var stopAll context.CancelFunc
+		workerParent, stopAll = context.WithCancel(context.WithoutCancel(ctx))
+		defer stopAll()
+	}
+
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			target := min(pattern.Target(time.Since(startTime)), cfg.Concurrency)
+			if cfg.GoroutineLimit > 0 && len(workers) < target {
+				n := runtime.NumGoroutine()
+				switch {
+				case n >= cfg.GoroutineLimit && !limited:
+					limited = true
+					r.log.Warn("Goroutine limit reached, holding ramp-up", "goroutines", n, "goroutineLimit", cfg.GoroutineLimit, "connections", len(workers))
+				case n < cfg.GoroutineLimit && limited:
+					limited = false
+					r.log.Info("Goroutines below the limit again, resuming ramp-up", "goroutines", n, "connections", len(workers))
+				}
+			}
+			switch {
+			case len(workers) < target && !paused && !limited:
+				for len(workers) < target && limiter.Allow() {
+					workerCtx, stop := context.WithCancel(workerParent)
+					wg.Add(1)
+					workerID++
+					t, dialer := pool.next(), r.dialer
+					switch {
+					case t.dialer != nil:
+						dialer = t.dialer
+					case r.sources != nil:
+						dialer = r.sources.next()
+					}
+					go r.worker(workerCtx, workerID, t, dialer, &wg)
+					workers = append(workers, stop)
+				}
+			case len(workers) > target:
+				for _, stop := range workers[target:] {
+					stop()
+				}
+				workers = workers[:target]
+			}
+			full := len(workers) == cfg.Concurrency
+			if cfg.RetryUntilTarget > 0 {
+				// Every worker starts early on; the target is the
+				// connections that are actually open.
+				full = full && r.fullAt.Load() != 0
+				if !full && !retryWarned && time.Now().After(r.retryDeadline) {
+					retryWarned = true
+					snap := r.takeSnapshot()
+					r.log.Warn("Target connection count not reached within --retry-until-target, failed workers back off as usual",
+						"active", snap.Active, "target", cfg.Concurrency, "attempts", snap.Attempts, "retryUntilTarget", cfg.RetryUntilTarget)
+				}
+			}
+			if !rampedUp && full {
+				rampedUp = true
+				// The achieved rate falls short of cfg.Rate when the
+				// pattern, a pause or the goroutine limit held the ramp-up,
+				// or ConnectOnly redials took some of the tokens.
+				elapsed := time.Since(startTime)
+				ramp := []any{
+					"connections", len(workers),
+					"rampUp", elapsed.Round(time.Millisecond),
+					"rate", cfg.Rate,
+					"achievedRate", roundRate(float64(len(workers)) / elapsed.Seconds()),
+				}
+				if cfg.RetryUntilTarget > 0 {
+					ramp = append(ramp, "attempts", r.attemptsToTarget.Load())
+				}
+				switch {
+				case cfg.Warmup > 0:
+					r.log.Info("Reached target connection count, warming up before measuring", append(ramp, "warmup", cfg.Warmup)...)
+					warmup := time.NewTimer(cfg.Warmup)
+					defer warmup.Stop()
+					warmupDone = warmup.C
+				case cfg.Duration > 0:
+					r.log.Info("Reached target connection count, waiting for test duration or interrupt", append(ramp, "duration", cfg.Duration)...)
+				default:
+					r.log.Info("Reached target connection count, waiting for interrupt (Ctrl+C)", ramp...)
+				}
+			}
+		case <-adapt:
+			from, to, attempts, rejected := controller.adjust(r)
+			switch {
+			case to < from:
+				r.log.Warn("Server is rate limiting handshakes, lowering the connection rate (--adaptive-rate)",
+					"from", from, "to", to, "attempts", attempts, "rejected429", rejected)
+			case to > from:
+				r.log.Info("Raising the connection rate (--adaptive-rate)", "from", from, "to", to)
+			}
+		case live := <-cfg.Reload:
+			r.applyLive(live)
+		case p := <-cfg.Pause:
+			if p == paused {
+				break
+			}
+			paused = p
+			if paused {
+				r.log.Info("Ramp-up paused", "connections", len(workers))
+			} else {
+				r.log.Info("Ramp-up resumed", "connections", len(workers))
+			}
+		case <-warmupDone:
+			warmupDone = nil
+			r.warmupEnd = r.takeSnapshot()
+			r.warmupFailures = r.failures.Snapshot()
+			r.warmupStatusCodes = r.statusCodes.Snapshot()
+			r.warmupCloseCodes = r.closeCodes.Snapshot()
+			measureStart = r.warmupEnd.Time
+			r.measuring.Store(true)
+			r.log.Info("Warmup complete, measurement window open")
+		case <-ctx.Done():
+			if !rampedUp {
+				r.log.Info("Stopping connection ramp-up due to shutdown signal")
+			}
+			break loop
+		}
+	}
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errDurationReached):
+		r.log.Info("Test duration reached, stopping workers")
+	case errors.Is(cause, errMaxMessages):
+		r.log.Info("Message limit reached, stopping workers", "maxMessages", cfg.MaxMessages)
+	case errors.Is(cause, errMaxBytes):
+		r.log.Info("Byte limit reached, stopping workers", "maxBytes", cfg.MaxBytes)
+	case errors.Is(cause, errMaxRuntime):
+		r.log.Warn("Max runtime reached before the test stopped, stopping workers", "maxRuntime", cfg.MaxRuntime)
+	case errors.Is(cause, errErrorRate):
+		snap := r.takeSnapshot()
+		r.log.Warn("Connection error rate exceeded the abort threshold, aborting the test",
+			"failed", snap.Failed, "attempts", snap.Attempts, "abortOnErrorRate", cfg.AbortErrorRate)
+	}
+
+	activeAtEnd := atomic.LoadInt64(&r.stats.active)
+	rampDownCut := false
+	if cfg.RampDownRate > 0 {
+		rampDownCut = !r.rampDown(workers, cfg.RampDownRate, hardDeadline)
+	}
+	r.log.Info("Waiting for active connections to close")
+	if rampDownCut || errors.Is(context.Cause(ctx), errMaxRuntime) {
+		// The run, or its ramp-down, was stopped by the limit itself, so
+		// give its connections a chance to close.
+		hardDeadline = time.Now().Add(cfg.CloseTimeout + closeSlack)
+	}
+	allClosed := r.waitForWorkers(&wg, hardDeadline)
+	endTime := time.Now()
+
+	// Only workers emit events, so the stream can end once they have.
+	// Workers still running when Config.MaxRuntime gave up on them may
+	// try to emit more, which the closed stream drops.
+	if r.events != nil {
+		if !allClosed {
+			r.log.Warn("The event stream may be missing the last events (--events)")
+		}
+		if err := r.events.Close(); err != nil {
+			r.log.Error("Failed to write events", "err", err)
+		}
+	}
+
+	// The dashboard stays up while connections close.
+	if stopDash != nil {
+		stopDash()
+	}
+
+	<-statsDone
+	if stopMetrics != nil {
+		stopMetrics()
+	}
+
+	summary := r.buildSummary(startTime, measureStart, endTime, activeAtEnd)
+	r.logSummary(summary, endTime.Sub(measureStart))
+	if reportOut != nil {
+		if err := r.writeReport(reportOut, summary); err != nil {
+			r.log.Error("Failed to write HTML report", "err", err)
+		} else {
+			r.log.Info("Wrote HTML report", "path", cfg.ReportPath)
+		}
+	}
+	return summary, nil
+}
+
+// closeSlack is how much longer than Config.CloseTimeout a run stopped by
+// Config.MaxRuntime waits, for connections to be torn down once their close
+// handshakes have timed out.
+const closeSlack = 250 * time.Millisecond
+
+// waitForWorkers waits for wg, but only until deadline unless it is zero.
+// It reports whether every worker returned in time.
+func (r *runner) waitForWorkers(wg *sync.WaitGroup, deadline time.Time) bool {
+	if deadline.IsZero() {
+		wg.Wait()
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		r.log.Warn("Max runtime reached, giving up on connections that didn't close in time",
+			"maxRuntime", r.cfg.MaxRuntime, "unclosed", atomic.LoadInt64(&r.stats.active))
+		return false
+	}
+}
+
+// applyLive applies reloaded settings, logging those that can't change
+// while the test runs.
+func (r *runner) applyLive(live LiveConfig) {
+	cfg := r.cfg
+	cur := &r.live
+	var applied, ignored []any
+
+	if live.StatsInterval != cur.StatsInterval {
+		if live.StatsInterval < 0 || (live.StatsInterval == 0 && cfg.ReportPath != "") {
+			ignored = append(ignored, "statsInterval", live.StatsInterval)
+		} else {
+			cur.StatsInterval = live.StatsInterval
+			// printStats may not have taken the previous one yet.
+			select {
+			case <-r.statsIntervals:
+			default:
+			}
+			r.statsIntervals <- live.StatsInterval
+			applied = append(applied, "statsInterval", live.StatsInterval)
+		}
+	}
+	if live.SendRate != cur.SendRate {
+		if live.SendRate <= 0 || !cfg.sendingEnabled() || cfg.ThinkTime > 0 || cfg.TargetRPS > 0 {
+			ignored = append(ignored, "sendRate", live.SendRate)
+		} else {
+			cur.SendRate = live.SendRate
+			r.sendRate.Store(int64(live.SendRate))
+			applied = append(applied, "sendRate", live.SendRate)
+		}
+	}
+	if live.TotalSendRate != cur.TotalSendRate {
+		if live.TotalSendRate <= 0 || r.sendLimiter == nil {
+			ignored = append(ignored, "totalSendRate", live.TotalSendRate)
+		} else {
+			cur.TotalSendRate = live.TotalSendRate
+			r.sendLimiter.SetLimit(rate.Limit(live.TotalSendRate))
+			applied = append(applied, "totalSendRate", live.TotalSendRate)
+		}
+	}
+
+	if len(applied) > 0 {
+		r.log.Info("Applied reloaded settings", applied...)
+	}
+	if len(ignored) > 0 {
+		r.log.Warn("Ignored reloaded settings that can't be applied to this run", ignored...)
+	}
+}
+
+// maxTickRate is the highest tick rate of the ramp-up loop. Finer timer
+// intervals aren't honored precisely, so faster rates start several
+// connections per tick instead.
+const maxTickRate = 100
+
+// rampTick returns the ramp-up loop's tick interval for a connection rate,
+// and the token bucket burst that lets each tick start its share.
+func rampTick(perSec int) (time.Duration, int) {
+	if perSec <= maxTickRate {
+		return time.Second / time.Duration(perSec), 1
+	}
+	return time.Second / maxTickRate, (perSec + maxTickRate - 1) / maxTickRate
+}
+
+// rampDown stops workers, newest first, at rate per second and logs the
+// progress about once a second. Once deadline passes, unless it is zero, it
+// stops the rest at once and reports false.
+func (r *runner) rampDown(workers []context.CancelFunc, rate int, deadline time.Time) bool {
+	r.log.Info("Ramping down", "workers", len(workers), "rate", rate)
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for i := range workers {
+		select {
+		case <-ticker.C:
+		case <-expired:
+			remaining := workers[:len(workers)-i]
+			r.log.Warn("Max runtime reached during ramp-down, stopping the remaining workers at once",
+				"maxRuntime", r.cfg.MaxRuntime, "remaining", len(remaining))
+			for _, stop := range remaining {
+				stop()
+			}
+			return false
+		}
+		workers[len(workers)-1-i]()
+		if closed := i + 1; closed%rate == 0 || closed == len(workers) {
+			r.log.Info("Ramp-down", "stopped", closed, "workers", len(workers))
+		}
+	}
+	return true
+}
+
+// maxLoggedTargets is how many URLs the starting record lists.
+const maxLoggedTargets = 10
+
+// logConfig logs the resolved configuration as a single record with message
+// msg, followed by warnings about risky settings.
+func (r *runner) logConfig(msg string) {
+	cfg := r.cfg
+
+	attrs := []any{}
+	if len(r.targets) == 1 {
+		attrs = append(attrs, "url", r.targets[0].url)
+	} else {
+		// A long URL file would drown the record, so only the first few
+		// are listed.
+		shown := r.targets[:min(len(r.targets), maxLoggedTargets)]
+		urls := make([]string, len(shown))
+		for i, t := range shown {
+			urls[i] = fmt.Sprintf("%s (weight %d)", t.url, t.weight)
+		}
+		attrs = append(attrs, "urls", urls)
+		if len(shown) < len(r.targets) {
+			attrs = append(attrs, "targets", len(r.targets))
+		}
+		if cfg.URLSelect == selectRandom {
+			attrs = append(attrs, "urlSelect", cfg.URLSelect)
+		}
+	}
+	if len(r.queryKeys) > 0 {
+		// The values may be credentials.
+		attrs = append(attrs, "query", r.queryKeys)
+	}
+	if r.cookies > 0 {
+		attrs = append(attrs, "cookies", r.cookies)
+	}
+	if cfg.LoginURL != "" {
+		attrs = append(attrs, "loginURL", cfg.LoginURL)
+	}
+	attrs = append(attrs,
+		"connections", cfg.Concurrency,
+		"rate", cfg.Rate,
+		"handshakeTimeout", cfg.HandshakeTimeout,
+		"seed", r.seed,
+	)
+	if cfg.Pattern != nil {
+		attrs = append(attrs, "pattern", fmt.Sprint(cfg.Pattern))
+	}
+	if len(cfg.LocalAddrs) > 0 {
+		attrs = append(attrs, "localAddrs", cfg.LocalAddrs)
+	}
+	if r.proxy != nil {
+		attrs = append(attrs, "proxy", r.proxy.Redacted())
+	}
+	if len(r.header) > 0 {
+		attrs = append(attrs, "customHeaders", len(r.header))
+	}
+	if len(cfg.Subprotocols) > 0 {
+		attrs = append(attrs, "subprotocols", cfg.Subprotocols)
+	}
+	if cfg.SNI != "" {
+		attrs = append(attrs, "sni", cfg.SNI)
+	}
+	if cfg.TLSMinVersion != "" {
+		attrs = append(attrs, "tlsMinVersion", cfg.TLSMinVersion)
+	}
+	if cfg.TLSMaxVersion != "" {
+		attrs = append(attrs, "tlsMaxVersion", cfg.TLSMaxVersion)
+	}
+	if len(cfg.CipherSuites) > 0 {
+		attrs = append(attrs, "cipherSuites", cfg.CipherSuites)
+	}
+	if cfg.sendingEnabled() {
+		frames := "text"
+		if cfg.Binary {
+			frames = "binary"
+		}
+		if cfg.ThinkTime > 0 {
+			attrs = append(attrs, "thinkTime", cfg.ThinkTime, "thinkJitter", cfg.ThinkJitter)
+		} else {
+			attrs = append(attrs, "sendRate", cfg.SendRate)
+		}
+		attrs = append(attrs, "payloadBytes", len(r.payload), "frames", frames)
+	}
+	if r.scenario != nil {
+		attrs = append(attrs, "scenarioSteps", len(r.scenario))
+	}
+	if cfg.Replay != nil {
+		msgs := cfg.Replay.Messages
+		attrs = append(attrs, "replayMessages", len(msgs), "replaySpan", msgs[len(msgs)-1].Offset, "replayLoop", cfg.ReplayLoop)
+	}
+	if cfg.TotalSendRate > 0 {
+		attrs = append(attrs, "totalSendRate", cfg.TotalSendRate)
+	}
+	if cfg.TargetRPS > 0 {
+		attrs = append(attrs, "targetRps", cfg.TargetRPS)
+	}
+	if cfg.Expect != "" {
+		attrs = append(attrs, "expect", cfg.Expect)
+	}
+	if cfg.Compression {
+		attrs = append(attrs, "compressionLevel", cfg.CompressionLevel)
+	}
+	if cfg.IdleTimeout > 0 {
+		attrs = append(attrs, "idleTimeout", cfg.IdleTimeout)
+	}
+	if cfg.BackpressureGap > 0 {
+		attrs = append(attrs, "backpressureGap", cfg.BackpressureGap)
+	}
+	if cfg.SlowRead > 0 {
+		attrs = append(attrs, "slowRead", cfg.SlowRead, "slowReadJitter", cfg.SlowReadJitter)
+	}
+	if cfg.Precheck {
+		attrs = append(attrs, "precheck", true)
+	}
+	if cfg.RetryUntilTarget > 0 {
+		attrs = append(attrs, "retryUntilTarget", cfg.RetryUntilTarget)
+	}
+	if cfg.RateWindow > 0 {
+		attrs = append(attrs, "rateWindow", cfg.RateWindow)
+	}
+	if cfg.SlowPong > 0 {
+		attrs = append(attrs, "slowPong", cfg.SlowPong)
+	}
+	if cfg.ConnectionLifetime > 0 {
+		attrs = append(attrs, "connectionLifetime", cfg.ConnectionLifetime, "durationJitter", cfg.LifetimeJitter)
+	}
+	if cfg.Echo {
+		attrs = append(attrs, "echo", true)
+	}
+	if cfg.CorrelationField != "" {
+		attrs = append(attrs, "correlate", cfg.CorrelationField, "responseTimeout", cfg.ResponseTimeout)
+	}
+	if cfg.Soak {
+		attrs = append(attrs, "soak", true, "keepalive", cfg.Keepalive)
+	}
+	if cfg.PoolSize > 0 {
+		attrs = append(attrs, "poolSize", cfg.PoolSize)
+	}
+	if cfg.ConnectOnly {
+		attrs = append(attrs, "connectOnly", true)
+	}
+	if cfg.Warmup > 0 {
+		attrs = append(attrs, "warmup", cfg.Warmup)
+	}
+	if cfg.RampDownRate > 0 {
+		attrs = append(attrs, "rampDownRate", cfg.RampDownRate)
+	}
+	if cfg.AdaptiveRate {
+		attrs = append(attrs, "adaptiveThreshold", cfg.AdaptiveThreshold)
+	}
+	if cfg.GoroutineLimit > 0 {
+		attrs = append(attrs, "goroutineLimit", cfg.GoroutineLimit)
+	}
+	if cfg.MaxMessages > 0 {
+		attrs = append(attrs, "maxMessages", cfg.MaxMessages)
+	}
+	if cfg.MaxBytes > 0 {
+		attrs = append(attrs, "maxBytes", cfg.MaxBytes)
+	}
+	if cfg.AbortErrorRate > 0 {
+		attrs = append(attrs, "abortOnErrorRate", cfg.AbortErrorRate, "abortMinAttempts", cfg.AbortMinAttempts)
+	}
+	if cfg.Duration > 0 {
+		attrs = append(attrs, "duration", cfg.Duration)
+	} else {
+		attrs = append(attrs, "duration", "unlimited")
+	}
+	if cfg.MaxRuntime > 0 {
+		attrs = append(attrs, "maxRuntime", cfg.MaxRuntime)
+	}
+	if cfg.CompressOutput {
+		attrs = append(attrs, "compressOutput", true)
+	}
+	r.log.Info(msg, attrs...)
+
+	if cfg.InsecureSkipVerify {
+		r.log.Warn("TLS certificate verification is disabled (--insecure-skip-verify)")
+	}
+	if cfg.ReadTimeout > 0 && !cfg.pingsShorterThan(cfg.ReadTimeout) {
+		r.log.Warn("Neither --ping-interval nor --keepalive is shorter than --read-timeout; idle connections will be reconnected",
+			"pingInterval", cfg.PingInterval, "keepalive", cfg.Keepalive, "readTimeout", cfg.ReadTimeout)
+	}
+}
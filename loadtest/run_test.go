@@ -0,0 +1,126 @@
+package loadtest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRunStopsAfterDuration(t *testing.T) {
+	cfg := testConfig(newEchoServer(t))
+	cfg.Duration = 200 * time.Millisecond
+
+	start := time.Now()
+	summary, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	// Closing the connections takes a little longer than the duration
+	// itself, but nowhere near another second.
+	if elapsed < cfg.Duration || elapsed > cfg.Duration+time.Second {
+		t.Errorf("run took %s, want about %s", elapsed, cfg.Duration)
+	}
+	if summary.Successful != int64(cfg.Concurrency) {
+		t.Errorf("successful = %d, want %d", summary.Successful, cfg.Concurrency)
+	}
+}
+
+func TestRunAbandonsHandshakesOnShutdown(t *testing.T) {
+	// A server that accepts connections but never answers the upgrade.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	cfg := testConfig("ws://" + ln.Addr().String() + "/")
+	cfg.Duration = 200 * time.Millisecond
+
+	start := time.Now()
+	summary, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > cfg.Duration+time.Second {
+		t.Errorf("run took %s, want the handshakes cut short after %s", elapsed, cfg.Duration)
+	}
+	if summary.Failed != 0 {
+		t.Errorf("failed = %d, want 0 for handshakes cut short by shutdown", summary.Failed)
+	}
+}
+
+func TestRampDownStopsAtMaxRuntime(t *testing.T) {
+	cfg := testConfig(newEchoServer(t))
+	cfg.Concurrency = 5
+	cfg.Duration = 200 * time.Millisecond
+	// Five seconds of ramp-down, cut short by the limit.
+	cfg.RampDownRate = 1
+	cfg.MaxRuntime = 500 * time.Millisecond
+	cfg.CloseTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	summary, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed, limit := time.Since(start), cfg.MaxRuntime+cfg.CloseTimeout+closeSlack+200*time.Millisecond; elapsed > limit {
+		t.Errorf("run took %s, want at most %s", elapsed, limit)
+	}
+	// The connections left once the limit cut the ramp-down short still
+	// get to close.
+	if summary.CleanCloses != int64(cfg.Concurrency) {
+		t.Errorf("cleanCloses = %d, want %d", summary.CleanCloses, cfg.Concurrency)
+	}
+}
+
+func TestRunShutdownPathsRace(t *testing.T) {
+	url := newEchoServer(t)
+	tests := []struct {
+		name string
+		// interrupt is when the parent context is cancelled, as on SIGINT,
+		// or zero for never.
+		interrupt  time.Duration
+		duration   time.Duration
+		maxRuntime time.Duration
+	}{
+		{name: "interrupt", interrupt: 50 * time.Millisecond},
+		{name: "duration", duration: 50 * time.Millisecond},
+		{name: "interrupt as duration ends", interrupt: 50 * time.Millisecond, duration: 50 * time.Millisecond},
+		{name: "interrupt as max runtime ends", interrupt: 60 * time.Millisecond, duration: 50 * time.Millisecond, maxRuntime: 60 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each race is run a few times, as the stops only meet now
+			// and then.
+			for range 10 {
+				cfg := testConfig(url)
+				cfg.Duration = tt.duration
+				cfg.MaxRuntime = tt.maxRuntime
+				cfg.CloseTimeout = 50 * time.Millisecond
+
+				ctx, cancel := context.WithCancel(context.Background())
+				if tt.interrupt > 0 {
+					stop := time.AfterFunc(tt.interrupt, cancel)
+					defer stop.Stop()
+				}
+				_, err := Run(ctx, cfg)
+				cancel()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultExpectTimeout bounds an expect step that sets no timeout.
+const defaultExpectTimeout = 10 * time.Second
+
+// scenarioBacklog is how many received messages are queued for a scenario
+// that is busy sending or sleeping; further messages are not matched.
+const scenarioBacklog = 64
+
+// A Scenario is a scripted conversation that every connection runs once it
+// is established, in place of the plain send loop. When the last step is
+// done the connection stays open and keeps reading.
+type Scenario struct {
+	Steps []Step `yaml:"steps"`
+}
+
+// A Step does exactly one thing: sends a message, waits for one that
+// matches a pattern, or pauses.
+type Step struct {
+	// Name labels the step in the summary. It defaults to a description of
+	// the step.
+	Name string `yaml:"name"`
+	// Send is a message to write. It may be a template like Config.Send.
+	Send string `yaml:"send"`
+	// Expect is a regular expression that a received message must match
+	// within Timeout. Messages that don't match are skipped.
+	Expect  string        `yaml:"expect"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Sleep pauses the scenario this long.
+	Sleep time.Duration `yaml:"sleep"`
+}
+
+// LoadScenario reads a scenario from a YAML or JSON file with a "steps"
+// list. Unknown keys are rejected.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var s Scenario
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// scenarioStep is a Step ready to run, with its counters.
+type scenarioStep struct {
+	name    string
+	send    []byte
+	tmpl    *template.Template
+	expect  *regexp.Regexp
+	timeout time.Duration
+	sleep   time.Duration
+
+	passed atomic.Int64
+	failed atomic.Int64
+}
+
+// compileScenario checks every step and parses its template or pattern.
+func compileScenario(s *Scenario) ([]*scenarioStep, error) {
+	if len(s.Steps) == 0 {
+		return nil, errors.New("no steps")
+	}
+	steps := make([]*scenarioStep, len(s.Steps))
+	for i, st := range s.Steps {
+		actions := 0
+		for _, set := range []bool{st.Send != "", st.Expect != "", st.Sleep != 0} {
+			if set {
+				actions++
+			}
+		}
+		if actions != 1 {
+			return nil, fmt.Errorf("step %d must have exactly one of send, expect or sleep", i+1)
+		}
+		if st.Timeout < 0 || st.Sleep < 0 {
+			return nil, fmt.Errorf("step %d: durations must not be negative", i+1)
+		}
+		if st.Timeout != 0 && st.Expect == "" {
+			return nil, fmt.Errorf("step %d: timeout only applies to expect", i+1)
+		}
+
+		step := &scenarioStep{name: st.Name, sleep: st.Sleep, timeout: st.Timeout}
+		switch {
+		case st.Send != "":
+			tmpl, err := parsePayloadTemplate(st.Send)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			step.send, step.tmpl = []byte(st.Send), tmpl
+			if step.name == "" {
+				step.name = "send"
+			}
+		case st.Expect != "":
+			re, err := regexp.Compile(st.Expect)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			step.expect = re
+			if step.timeout == 0 {
+				step.timeout = defaultExpectTimeout
+			}
+			if step.name == "" {
+				step.name = "expect " + st.Expect
+			}
+		default:
+			if step.name == "" {
+				step.name = "sleep " + st.Sleep.String()
+			}
+		}
+		steps[i] = step
+	}
+	return steps, nil
+}
+
+// runScenario plays the scenario on one connection, taking received
+// messages from inbox. A failed step closes the connection so the read loop
+// reconnects; a connection that ends mid-step is not counted against it.
+func (r *runner) runScenario(ctx context.Context, id uint64, conn *safeConn, connDone <-chan struct{}, inbox <-chan []byte) {
+	var buf bytes.Buffer
+	var seq uint64
+
+	for i, step := range r.scenario {
+		var err error
+		switch {
+		case step.expect != nil:
+			err = awaitMatch(ctx, connDone, inbox, step.expect, step.timeout)
+		case step.sleep > 0:
+			if !sleepCtx(ctx, step.sleep) {
+				return
+			}
+		default:
+			if r.sendLimiter != nil && !r.waitSendToken(ctx, connDone) {
+				return
+			}
+			payload := step.send
+			if step.tmpl != nil {
+				payload, err = renderPayload(step.tmpl, &buf, payloadData{ConnID: id, Seq: seq, Timestamp: time.Now().UnixNano()})
+				if err != nil {
+					break
+				}
+			}
+			seq++
+			if err = conn.WriteMessage(r.cfg.messageType(), payload); err == nil {
+				r.countSent(conn, len(payload))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-connDone:
+			return
+		default:
+		}
+		if err != nil {
+			step.failed.Add(1)
+			conn.log.Debug("Scenario step failed", "step", i+1, "name", step.name, "err", err)
+			conn.Close()
+			return
+		}
+		step.passed.Add(1)
+	}
+}
+
+var errExpectTimeout = errors.New("no matching message before the timeout")
+
+// awaitMatch waits for a message in inbox that matches re.
+func awaitMatch(ctx context.Context, connDone <-chan struct{}, inbox <-chan []byte, re *regexp.Regexp, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case p := <-inbox:
+			if re.Match(p) {
+				return nil
+			}
+		case <-timer.C:
+			return errExpectTimeout
+		case <-connDone:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package loadtest
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// soakBuckets is how many groups the soak pinger spreads connections over,
+// so their pings go out evenly across the keepalive interval rather than
+// all at once.
+const soakBuckets = 20
+
+// soakPingTimeout bounds each soak ping.
+const soakPingTimeout = 5 * time.Second
+
+// soakPingFanOut is how many pings of a group are written at a time, so a
+// few connections whose send buffers are full delay the rest of their group
+// by soakPingTimeout at most, rather than by that much each.
+const soakPingFanOut = 16
+
+// soakPinger is the one keepalive scheduler of Config.Soak, standing in for
+// a ping goroutine per connection. Connections are registered in one of
+// soakBuckets groups by id, and every tick pings the next group.
+type soakPinger struct {
+	mu      sync.Mutex
+	buckets [soakBuckets]map[*safeConn]struct{}
+}
+
+// add registers conn and returns the func that removes it again.
+func (p *soakPinger) add(id uint64, conn *safeConn) func() {
+	b := id % soakBuckets
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.buckets[b] == nil {
+		p.buckets[b] = make(map[*safeConn]struct{})
+	}
+	p.buckets[b][conn] = struct{}{}
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.buckets[b], conn)
+	}
+}
+
+// group returns a copy of the connections in bucket b.
+func (p *soakPinger) group(b int) []*safeConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := make([]*safeConn, 0, len(p.buckets[b]))
+	for c := range p.buckets[b] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// runSoakPinger pings every registered connection once per interval until
+// ctx is done. A failed ping closes the connection so its read loop
+// reconnects.
+func (r *runner) runSoakPinger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval / soakBuckets)
+	defer ticker.Stop()
+
+	for b := 0; ; b = (b + 1) % soakBuckets {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		r.pingGroup(r.soak.group(b))
+	}
+}
+
+// pingGroup pings conns, soakPingFanOut at a time, and returns once every
+// ping has been written or has failed.
+func (r *runner) pingGroup(conns []*safeConn) {
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range min(len(conns), soakPingFanOut) {
+		wg.Go(func() {
+			for i := next.Add(1) - 1; i < int64(len(conns)); i = next.Add(1) - 1 {
+				conn := conns[i]
+				// WriteControl may be called alongside the connection's
+				// other writes, so the safeConn lock isn't needed.
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(soakPingTimeout)); err != nil {
+					conn.log.Debug("Ping failed", "err", err)
+					conn.Close()
+					continue
+				}
+				atomic.AddInt64(&r.stats.pingsSent, 1)
+			}
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,56 @@
+package loadtest
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestPingGroup(t *testing.T) {
+	var pings atomic.Int64
+	url := newWSServer(t, func(conn *websocket.Conn, _ *http.Request) {
+		conn.SetPingHandler(func(string) error {
+			pings.Add(1)
+			return nil
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+	r, err := newRunner(testConfig(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More connections than are pinged at a time, one of them closed
+	// already.
+	conns := make([]*safeConn, 2*soakPingFanOut+1)
+	for i := range conns {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conns[i] = &safeConn{Conn: conn, log: slog.New(slog.DiscardHandler)}
+	}
+	conns[0].Close()
+
+	r.pingGroup(conns)
+	want := int64(len(conns) - 1)
+	if got := atomic.LoadInt64(&r.stats.pingsSent); got != want {
+		t.Errorf("pingsSent = %d, want %d", got, want)
+	}
+	deadline := time.Now().Add(time.Second)
+	for pings.Load() < want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := pings.Load(); got != want {
+		t.Errorf("server received %d pings, want %d", got, want)
+	}
+}
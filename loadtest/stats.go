@@ -0,0 +1,358 @@
+package loadtest
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tally counts occurrences of string keys. It is safe for concurrent use.
+type tally struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// Add counts one occurrence of key and returns its new count.
+func (t *tally) Add(key string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts == nil {
+		t.counts = make(map[string]int64)
+	}
+	t.counts[key]++
+	return t.counts[key]
+}
+
+// Snapshot returns a copy of the counts.
+func (t *tally) Snapshot() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]int64, len(t.counts))
+	for k, v := range t.counts {
+		out[k] = v
+	}
+	return out
+}
+
+// subtractCounts returns the counts in m that are not already in base,
+// dropping keys that end up zero.
+func subtractCounts(m, base map[string]int64) map[string]int64 {
+	out := make(map[string]int64, len(m))
+	for k, v := range m {
+		if d := v - base[k]; d != 0 {
+			out[k] = d
+		}
+	}
+	return out
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// statsSnapshot is a point-in-time copy of the run counters.
+type statsSnapshot struct {
+	Time          time.Time
+	Active        int64
+	Attempts      int64
+	Succeeded     int64
+	Failed        int64
+	Reconnects    int64
+	BytesRead     int64
+	MessagesSent  int64
+	BytesWritten  int64
+	PingsSent     int64
+	PongsReceived int64
+	PingsReceived int64
+	PongsSent     int64
+	Churned       int64
+	Heartbeats    int64
+	Replays       int64
+	CleanCloses   int64
+	AbruptCloses  int64
+	// AssertionFailures counts text messages that didn't match Config.Expect.
+	AssertionFailures int64
+	Oversized         int64
+	Compressed        int64
+	Stalled           int64
+	Responses         int64
+	ResponseTimeouts  int64
+	Backpressured     int64
+}
+
+func (r *runner) takeSnapshot() statsSnapshot {
+	return statsSnapshot{
+		Time:              time.Now(),
+		Active:            atomic.LoadInt64(&r.stats.active),
+		Attempts:          atomic.LoadInt64(&r.stats.attempts),
+		Succeeded:         atomic.LoadInt64(&r.stats.successful),
+		Failed:            atomic.LoadInt64(&r.stats.failed),
+		Reconnects:        atomic.LoadInt64(&r.stats.reconnects),
+		BytesRead:         atomic.LoadInt64(&r.stats.bytesRead),
+		MessagesSent:      atomic.LoadInt64(&r.stats.messagesSent),
+		BytesWritten:      atomic.LoadInt64(&r.stats.bytesWritten),
+		PingsSent:         atomic.LoadInt64(&r.stats.pingsSent),
+		PongsReceived:     atomic.LoadInt64(&r.stats.pongsReceived),
+		PingsReceived:     atomic.LoadInt64(&r.stats.pingsReceived),
+		PongsSent:         atomic.LoadInt64(&r.stats.pongsSent),
+		Churned:           atomic.LoadInt64(&r.stats.churned),
+		CleanCloses:       atomic.LoadInt64(&r.stats.cleanCloses),
+		AbruptCloses:      atomic.LoadInt64(&r.stats.abruptCloses),
+		Heartbeats:        atomic.LoadInt64(&r.stats.heartbeatsSent),
+		Replays:           atomic.LoadInt64(&r.stats.replaysCompleted),
+		AssertionFailures: atomic.LoadInt64(&r.stats.assertionFailures),
+		Oversized:         atomic.LoadInt64(&r.stats.oversized),
+		Compressed:        atomic.LoadInt64(&r.stats.compressed),
+		Stalled:           atomic.LoadInt64(&r.stats.stalled),
+		Responses:         atomic.LoadInt64(&r.stats.responses),
+		ResponseTimeouts:  atomic.LoadInt64(&r.stats.responseTimeouts),
+		Backpressured:     atomic.LoadInt64(&r.stats.backpressured),
+	}
+}
+
+// statsRates are per-second rates between two snapshots.
+type statsRates struct {
+	AttemptsPerSec  float64
+	ConnsPerSec     float64
+	BytesReadPerSec float64
+	MessagesPerSec  float64
+	// ServerPingsPerSec is the rate of pings received from the server.
+	ServerPingsPerSec float64
+}
+
+// ratesSince returns the per-second change from prev to s.
+func (s statsSnapshot) ratesSince(prev statsSnapshot) statsRates {
+	elapsed := s.Time.Sub(prev.Time).Seconds()
+	if elapsed <= 0 {
+		return statsRates{}
+	}
+	return statsRates{
+		AttemptsPerSec:    float64(s.Attempts-prev.Attempts) / elapsed,
+		ConnsPerSec:       float64(s.Succeeded-prev.Succeeded) / elapsed,
+		BytesReadPerSec:   float64(s.BytesRead-prev.BytesRead) / elapsed,
+		MessagesPerSec:    float64(s.MessagesSent-prev.MessagesSent) / elapsed,
+		ServerPingsPerSec: float64(s.PingsReceived-prev.PingsReceived) / elapsed,
+	}
+}
+
+// printStats logs the counters every interval until ctx is done, and whenever
+// Config.StatusRequests asks for them. An interval of zero disables periodic
+// stats. If csvOut is non-nil each snapshot is also
+// appended to it, and it is closed on return. With a report path set, each
+// interval is kept in r.samples as well.
+func (r *runner) printStats(ctx context.Context, interval time.Duration, csvOut *csvStats) {
+	if csvOut != nil {
+		defer func() {
+			if err := csvOut.Close(); err != nil {
+				r.log.Error("Failed to close CSV stats file", "err", err)
+			}
+		}()
+	}
+
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	setInterval := func(d time.Duration) {
+		switch {
+		case d <= 0 && ticker != nil:
+			ticker.Stop()
+			ticker, tick = nil, nil
+		case d > 0 && ticker != nil:
+			ticker.Reset(d)
+		case d > 0:
+			ticker = time.NewTicker(d)
+			tick = ticker.C
+		}
+	}
+	setInterval(interval)
+	defer setInterval(0)
+
+	prev := r.takeSnapshot()
+	var window *rateWindow
+	if r.cfg.RateWindow > 0 {
+		window = &rateWindow{span: r.cfg.RateWindow}
+		window.add(prev)
+	}
+	var prevHandshake, prevEcho LatencyStats
+	if r.cfg.ReportPath != "" {
+		r.samples = append(r.samples, reportSample{Snap: prev})
+	}
+
+	for {
+		select {
+		case <-tick:
+			snap := r.takeSnapshot()
+			rates := snap.ratesSince(prev)
+			prev = snap
+			window.add(snap)
+			if r.cfg.ReportPath != "" {
+				handshake, echo := r.handshakes.Snapshot(), r.echoLatency.Snapshot()
+				r.samples = append(r.samples, reportSample{
+					Elapsed:   snap.Time.Sub(r.samples[0].Snap.Time),
+					Snap:      snap,
+					Rates:     rates,
+					Handshake: intervalAvg(prevHandshake, handshake),
+					Echo:      intervalAvg(prevEcho, echo),
+				})
+				prevHandshake, prevEcho = handshake, echo
+			}
+			if !r.cfg.Quiet && r.dash == nil {
+				r.log.Info("Status", r.statusAttrs(snap, rates, window)...)
+			}
+			if csvOut != nil {
+				if err := csvOut.Write(snap); err != nil {
+					r.log.Error("Failed to write CSV stats", "err", err)
+				}
+			}
+		case d := <-r.statsIntervals:
+			setInterval(d)
+		case <-r.cfg.StatusRequests:
+			// Rates are since the last periodic record, which stays the
+			// baseline for the next one.
+			snap := r.takeSnapshot()
+			r.log.Info("Status (requested)", r.statusAttrs(snap, snap.ratesSince(prev), window)...)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rateWindow holds the periodic snapshots of the last Config.RateWindow, the
+// oldest being the last one taken at least that long ago, to average rates
+// over. A nil window holds nothing.
+type rateWindow struct {
+	span  time.Duration
+	snaps []statsSnapshot
+}
+
+// add appends s and drops the snapshots that fell out of the window.
+func (w *rateWindow) add(s statsSnapshot) {
+	if w == nil {
+		return
+	}
+	w.snaps = append(w.snaps, s)
+	drop := 0
+	for drop+1 < len(w.snaps) && s.Time.Sub(w.snaps[drop+1].Time) >= w.span {
+		drop++
+	}
+	w.snaps = slices.Delete(w.snaps, 0, drop)
+}
+
+// value returns the rates and error rate from the start of the window to s
+// as a group, with the span they actually cover; until the run is as old
+// as the window, that is shorter.
+func (w *rateWindow) value(r *runner, s statsSnapshot) slog.Attr {
+	base := w.snaps[0]
+	errorRate := 0.0
+	if attempts := s.Attempts - base.Attempts; attempts > 0 {
+		errorRate = float64(s.Failed-base.Failed) / float64(attempts)
+	}
+	attrs := append([]any{"span", s.Time.Sub(base.Time).Round(time.Second)},
+		r.rateAttrs(s.ratesSince(base))...)
+	return slog.Group("window", append(attrs, slog.Float64("errorRate", math.Round(errorRate*1e4)/1e4))...)
+}
+
+// statusAttrs are the attributes of a periodic status record. Optional
+// groups only appear when the feature they describe is enabled.
+func (r *runner) statusAttrs(snap statsSnapshot, rates statsRates, window *rateWindow) []any {
+	attrs := []any{
+		"active", snap.Active,
+		"attempts", snap.Attempts,
+		"succeeded", snap.Succeeded,
+		"failed", snap.Failed,
+		"reconnects", snap.Reconnects,
+		"bytesRead", snap.BytesRead,
+	}
+	if r.cfg.sendsMessages() {
+		attrs = append(attrs, "messagesSent", snap.MessagesSent, "bytesWritten", snap.BytesWritten)
+	}
+
+	if r.expect != nil {
+		attrs = append(attrs, "assertionFailures", snap.AssertionFailures)
+	}
+	if r.cfg.Compression {
+		attrs = append(attrs, "compressed", snap.Compressed)
+	}
+	if r.cfg.IdleTimeout > 0 {
+		attrs = append(attrs, "stalledConnections", snap.Stalled)
+	}
+	if r.cfg.BackpressureGap > 0 {
+		attrs = append(attrs, "backpressuredConnections", snap.Backpressured)
+	}
+	if snap.Oversized > 0 {
+		attrs = append(attrs, "oversizedMessages", snap.Oversized)
+	}
+
+	attrs = append(attrs, slog.Group("rates", r.rateAttrs(rates)...))
+	if window != nil {
+		attrs = append(attrs, window.value(r, snap))
+	}
+
+	if snap.Failed > 0 {
+		attrs = append(attrs, "failures", countsValue(r.failures.Snapshot()))
+	}
+	if r.cfg.ConnectionLifetime > 0 {
+		attrs = append(attrs, slog.Group("churn", "opened", snap.Succeeded, "closedByLifetime", snap.Churned))
+	}
+	if r.cfg.pingsEnabled() {
+		attrs = append(attrs, slog.Group("keepalive", "pingsSent", snap.PingsSent, "pongsReceived", snap.PongsReceived))
+	}
+	if snap.PingsReceived > 0 || r.cfg.SlowPong > 0 {
+		attrs = append(attrs, slog.Group("serverPings", "received", snap.PingsReceived,
+			slog.Float64("perSec", roundRate(rates.ServerPingsPerSec)), "pongsSent", snap.PongsSent,
+			"interval", r.serverPingInterval.Snapshot()))
+	}
+	if r.cfg.HeartbeatInterval > 0 {
+		attrs = append(attrs, "heartbeatsSent", snap.Heartbeats)
+	}
+	if r.cfg.Replay != nil {
+		attrs = append(attrs, "replaysCompleted", snap.Replays)
+	}
+	attrs = append(attrs, "handshakeLatency", r.handshakes.Snapshot())
+	if r.cfg.Echo {
+		attrs = append(attrs, "echoLatency", r.echoLatency.Snapshot())
+	}
+	if r.cfg.CorrelationField != "" {
+		attrs = append(attrs, "responses", snap.Responses, "responseTimeouts", snap.ResponseTimeouts,
+			"responseLatency", r.responseLatency.Snapshot())
+	}
+	return attrs
+}
+
+// roundRate keeps one decimal of a per-second rate.
+func roundRate(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// rateAttrs are the per-second rates of a status record.
+func (r *runner) rateAttrs(rates statsRates) []any {
+	attrs := []any{
+		slog.Float64("attemptsPerSec", roundRate(rates.AttemptsPerSec)),
+		slog.Float64("connsPerSec", roundRate(rates.ConnsPerSec)),
+		slog.Float64("bytesReadPerSec", roundRate(rates.BytesReadPerSec)),
+	}
+	if r.cfg.sendsMessages() {
+		attrs = append(attrs, slog.Float64("messagesSentPerSec", roundRate(rates.MessagesPerSec)))
+	}
+	return attrs
+}
+
+// countsValue renders a tally snapshot as a group with one attribute per
+// key, in key order.
+func countsValue(m map[string]int64) slog.Value {
+	attrs := make([]slog.Attr, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		attrs = append(attrs, slog.Int64(k, m[k]))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,500 @@
+package loadtest
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Summary is the final result of a run. With a warmup, the counters and
+// DurationMs cover only the measurement window and Warmup holds the rest.
+type Summary struct {
+	DurationMs float64 `json:"durationMs"`
+	// Attempts counts every dial, reconnects included, and SuccessRatio
+	// is the share of them that connected.
+	Attempts     int64   `json:"attempts"`
+	SuccessRatio float64 `json:"successRatio"`
+	Successful   int64   `json:"successful"`
+	Failed       int64   `json:"failed"`
+	Reconnects   int64   `json:"reconnects"`
+	ActiveAtEnd  int64   `json:"activeAtEnd"`
+	// PeakActive is the most connections open at once at any point of the
+	// run, which under churn or reconnects says more about the load the
+	// server held than ActiveAtEnd.
+	PeakActive int64 `json:"peakActive"`
+	// CleanCloses and AbruptCloses count the connections the client closed,
+	// on shutdown or with Config.ConnectOnly, whose server did and didn't
+	// answer the close frame within Config.CloseTimeout.
+	CleanCloses  int64 `json:"cleanCloses"`
+	AbruptCloses int64 `json:"abruptCloses"`
+	// HandshakesPerSec is, with Config.ConnectOnly, the rate of completed
+	// handshakes over the run.
+	HandshakesPerSec float64 `json:"handshakesPerSec,omitempty"`
+	BytesRead        int64   `json:"bytesRead"`
+	MessagesSent     int64   `json:"messagesSent,omitempty"`
+	BytesWritten     int64   `json:"bytesWritten,omitempty"`
+	PingsSent        int64   `json:"pingsSent"`
+	PongsReceived    int64   `json:"pongsReceived"`
+	// PingsReceived counts the pings the server sent, PongsSent the pongs
+	// answering them and ServerPingInterval the time between consecutive
+	// pings on a connection. They are omitted if the server sent none.
+	PingsReceived      int64         `json:"pingsReceived,omitempty"`
+	PongsSent          int64         `json:"pongsSent,omitempty"`
+	ServerPingInterval *LatencyStats `json:"serverPingInterval,omitempty"`
+	// TargetRPS is Config.TargetRPS and AchievedRPS the messages actually
+	// sent per second over the measurement window.
+	TargetRPS   int     `json:"targetRps,omitempty"`
+	AchievedRPS float64 `json:"achievedRps,omitempty"`
+	// HeartbeatsSent counts Config.HeartbeatMessage sends.
+	HeartbeatsSent int64 `json:"heartbeatsSent,omitempty"`
+	// ReplaysCompleted counts, with Config.Replay, the passes through the
+	// replay that sent every message.
+	ReplaysCompleted int64 `json:"replaysCompleted,omitempty"`
+	// RampUpMs is how long after the start Config.Concurrency connections
+	// were first open at once; zero if that never happened.
+	RampUpMs float64 `json:"rampUpMs,omitempty"`
+	// AttemptsToTarget is, with Config.RetryUntilTarget, how many dials it
+	// took to have Config.Concurrency connections open; zero if that never
+	// happened.
+	AttemptsToTarget int64 `json:"attemptsToTarget,omitempty"`
+	// Seed is the random seed the run used; pass it as Config.Seed to
+	// replay the run's random choices.
+	Seed uint64 `json:"seed"`
+	// AssertionFailures counts received text messages that didn't match
+	// Config.Expect.
+	AssertionFailures int64 `json:"assertionFailures,omitempty"`
+	// Compressed counts, with Config.Compression, the connections whose
+	// handshake negotiated permessage-deflate.
+	Compressed int64 `json:"compressed,omitempty"`
+	// StalledConnections counts connections closed by Config.IdleTimeout.
+	StalledConnections int64 `json:"stalledConnections,omitempty"`
+	// BackpressuredConnections counts connections whose sent messages got
+	// ahead of those received by more than Config.BackpressureGap.
+	BackpressuredConnections int64 `json:"backpressuredConnections,omitempty"`
+	// OversizedMessages counts connections closed because a message
+	// exceeded Config.MaxMessageSize.
+	OversizedMessages int64 `json:"oversizedMessages,omitempty"`
+	// ConnectionsOpened and Churned are only set with a connection
+	// lifetime: every connection opened, and those closed on reaching their
+	// lifetime.
+	ConnectionsOpened int64         `json:"connectionsOpened,omitempty"`
+	Churned           int64         `json:"churned,omitempty"`
+	HandshakeLatency  *LatencyStats `json:"handshakeLatency,omitempty"`
+	EchoLatency       *LatencyStats `json:"echoLatency,omitempty"`
+	// Responses, ResponseTimeouts and ResponseLatency are only set with
+	// Config.CorrelationField: the requests answered within
+	// Config.ResponseTimeout, those that weren't, and the time to each
+	// answer. ResponseTimeoutRate is the share of requests that timed out.
+	Responses           int64         `json:"responses,omitempty"`
+	ResponseTimeouts    int64         `json:"responseTimeouts,omitempty"`
+	ResponseTimeoutRate float64       `json:"responseTimeoutRate,omitempty"`
+	ResponseLatency     *LatencyStats `json:"responseLatency,omitempty"`
+	// FirstMessageLatency is the time from handshake to the first received
+	// message on each connection, and Interarrival the gaps between the
+	// messages after it. They are only set if messages were received.
+	FirstMessageLatency *LatencyStats `json:"firstMessageLatency,omitempty"`
+	Interarrival        *LatencyStats `json:"interarrival,omitempty"`
+	// EventsDropped counts the events Config.EventsPath lost because its
+	// writer fell behind.
+	EventsDropped int64 `json:"eventsDropped,omitempty"`
+	// FailureCategories counts Failed by cause; see the README for the
+	// categories.
+	FailureCategories map[string]int64 `json:"failureCategories,omitempty"`
+	// StatusCodes counts rejected handshakes by HTTP status code.
+	StatusCodes map[string]int64 `json:"statusCodes,omitempty"`
+	// CloseCodes counts the close frames servers ended connections with by
+	// close code, 1006 standing for a connection dropped without one.
+	// CloseReasons holds the reason text that came with the first frame of
+	// each code, for the codes that had one.
+	CloseCodes   map[string]int64  `json:"closeCodes,omitempty"`
+	CloseReasons map[string]string `json:"closeReasons,omitempty"`
+	Targets      []TargetSummary   `json:"targets,omitempty"`
+	// Precheck holds, with Config.Precheck, the handshake latency of the
+	// probe of each URL.
+	Precheck []PrecheckSummary `json:"precheck,omitempty"`
+	// Subprotocols maps each negotiated subprotocol to the number of
+	// handshakes that selected it; "" means none was selected.
+	Subprotocols map[string]int64 `json:"subprotocols,omitempty"`
+	Warmup       *PhaseSummary    `json:"warmup,omitempty"`
+	// TLSVersions maps each TLS version negotiated by wss:// handshakes,
+	// such as "1.3", to the number of handshakes that did.
+	TLSVersions map[string]int64 `json:"tlsVersions,omitempty"`
+	// Scenario reports each step of Config.Scenario over the whole run.
+	Scenario []StepSummary `json:"scenario,omitempty"`
+}
+
+// ErrorRate returns the fraction of connection attempts that failed, or zero
+// if there were none.
+func (s Summary) ErrorRate() float64 {
+	if s.Attempts == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Attempts)
+}
+
+// StepSummary counts how often a scenario step passed and failed.
+type StepSummary struct {
+	Step   int    `json:"step"`
+	Name   string `json:"name"`
+	Passed int64  `json:"passed"`
+	Failed int64  `json:"failed"`
+}
+
+// PhaseSummary holds the counters accumulated during one phase of a run.
+type PhaseSummary struct {
+	DurationMs    float64 `json:"durationMs"`
+	Attempts      int64   `json:"attempts"`
+	Successful    int64   `json:"successful"`
+	Failed        int64   `json:"failed"`
+	Reconnects    int64   `json:"reconnects"`
+	BytesRead     int64   `json:"bytesRead"`
+	MessagesSent  int64   `json:"messagesSent,omitempty"`
+	BytesWritten  int64   `json:"bytesWritten,omitempty"`
+	PingsSent     int64   `json:"pingsSent"`
+	PongsReceived int64   `json:"pongsReceived"`
+}
+
+// TargetSummary holds the per-URL counters when more than one URL is given.
+type TargetSummary struct {
+	URL        string `json:"url"`
+	Weight     int    `json:"weight"`
+	Successful int64  `json:"successful"`
+	Failed     int64  `json:"failed"`
+}
+
+// buildSummary snapshots the run counters. The measurement window runs from
+// measureStart to end; anything before it is reported as the warmup phase.
+// activeAtEnd is sampled by the caller when the test stops, before workers
+// close their connections.
+func (r *runner) buildSummary(start, measureStart, end time.Time, activeAtEnd int64) Summary {
+	snap := r.takeSnapshot()
+	if r.cfg.Warmup > 0 && !r.measuring.Load() {
+		// Stopped before the warmup was over: nothing was measured.
+		r.warmupEnd = snap
+		r.warmupFailures = r.failures.Snapshot()
+		r.warmupStatusCodes = r.statusCodes.Snapshot()
+		r.warmupCloseCodes = r.closeCodes.Snapshot()
+		measureStart = end
+	}
+	base := r.warmupEnd
+	summary := Summary{
+		DurationMs:               durationMs(end.Sub(measureStart)),
+		Attempts:                 snap.Attempts - base.Attempts,
+		Successful:               snap.Succeeded - base.Succeeded,
+		Failed:                   snap.Failed - base.Failed,
+		Reconnects:               snap.Reconnects - base.Reconnects,
+		ActiveAtEnd:              activeAtEnd,
+		BytesRead:                snap.BytesRead - base.BytesRead,
+		MessagesSent:             snap.MessagesSent - base.MessagesSent,
+		BytesWritten:             snap.BytesWritten - base.BytesWritten,
+		PingsSent:                snap.PingsSent - base.PingsSent,
+		PongsReceived:            snap.PongsReceived - base.PongsReceived,
+		CleanCloses:              snap.CleanCloses - base.CleanCloses,
+		AbruptCloses:             snap.AbruptCloses - base.AbruptCloses,
+		PingsReceived:            snap.PingsReceived - base.PingsReceived,
+		PongsSent:                snap.PongsSent - base.PongsSent,
+		HeartbeatsSent:           snap.Heartbeats - base.Heartbeats,
+		ReplaysCompleted:         snap.Replays - base.Replays,
+		Seed:                     r.seed,
+		AssertionFailures:        snap.AssertionFailures - base.AssertionFailures,
+		OversizedMessages:        snap.Oversized - base.Oversized,
+		Compressed:               snap.Compressed - base.Compressed,
+		StalledConnections:       snap.Stalled - base.Stalled,
+		BackpressuredConnections: snap.Backpressured - base.Backpressured,
+	}
+	if r.cfg.ConnectOnly && summary.DurationMs > 0 {
+		summary.HandshakesPerSec = float64(summary.Successful) / (summary.DurationMs / 1000)
+	}
+	if r.cfg.TargetRPS > 0 {
+		summary.TargetRPS = r.cfg.TargetRPS
+		if summary.DurationMs > 0 {
+			summary.AchievedRPS = float64(summary.MessagesSent) / (summary.DurationMs / 1000)
+		}
+	}
+	if summary.Attempts > 0 {
+		summary.SuccessRatio = float64(summary.Successful) / float64(summary.Attempts)
+	}
+	summary.PeakActive = r.peakActive.Load()
+	if r.events != nil {
+		summary.EventsDropped = r.events.dropped.Load()
+	}
+	if at := r.fullAt.Load(); at != 0 {
+		summary.RampUpMs = durationMs(time.Unix(0, at).Sub(start))
+		if r.cfg.RetryUntilTarget > 0 {
+			summary.AttemptsToTarget = r.attemptsToTarget.Load()
+		}
+	}
+	if r.cfg.Warmup > 0 {
+		summary.Warmup = &PhaseSummary{
+			DurationMs:    durationMs(measureStart.Sub(start)),
+			Attempts:      base.Attempts,
+			Successful:    base.Succeeded,
+			Failed:        base.Failed,
+			Reconnects:    base.Reconnects,
+			BytesRead:     base.BytesRead,
+			MessagesSent:  base.MessagesSent,
+			BytesWritten:  base.BytesWritten,
+			PingsSent:     base.PingsSent,
+			PongsReceived: base.PongsReceived,
+		}
+	}
+	if summary.Failed > 0 {
+		summary.FailureCategories = subtractCounts(r.failures.Snapshot(), r.warmupFailures)
+	}
+	if codes := subtractCounts(r.statusCodes.Snapshot(), r.warmupStatusCodes); len(codes) > 0 {
+		summary.StatusCodes = codes
+	}
+	if codes := subtractCounts(r.closeCodes.Snapshot(), r.warmupCloseCodes); len(codes) > 0 {
+		summary.CloseCodes = codes
+		for code := range codes {
+			if reason, ok := r.closeReasons.Load(code); ok {
+				if summary.CloseReasons == nil {
+					summary.CloseReasons = make(map[string]string)
+				}
+				summary.CloseReasons[code] = reason.(string)
+			}
+		}
+	}
+	if r.cfg.ConnectionLifetime > 0 {
+		summary.ConnectionsOpened = summary.Successful
+		summary.Churned = snap.Churned - base.Churned
+	}
+	if stats := r.handshakes.Snapshot(); stats.Count > 0 {
+		summary.HandshakeLatency = &stats
+	}
+	if r.cfg.Echo {
+		stats := r.echoLatency.Snapshot()
+		summary.EchoLatency = &stats
+	}
+	if r.cfg.CorrelationField != "" {
+		summary.Responses = snap.Responses - base.Responses
+		summary.ResponseTimeouts = snap.ResponseTimeouts - base.ResponseTimeouts
+		if n := summary.Responses + summary.ResponseTimeouts; n > 0 {
+			summary.ResponseTimeoutRate = float64(summary.ResponseTimeouts) / float64(n)
+		}
+		stats := r.responseLatency.Snapshot()
+		summary.ResponseLatency = &stats
+	}
+	if stats := r.firstMessage.Snapshot(); stats.Count > 0 {
+		summary.FirstMessageLatency = &stats
+	}
+	if stats := r.interarrival.Snapshot(); stats.Count > 0 {
+		summary.Interarrival = &stats
+	}
+	if stats := r.serverPingInterval.Snapshot(); stats.Count > 0 {
+		summary.ServerPingInterval = &stats
+	}
+	if len(r.cfg.Subprotocols) > 0 {
+		summary.Subprotocols = r.subprotocols.Snapshot()
+	}
+	if versions := r.tlsVersions.Snapshot(); len(versions) > 0 {
+		summary.TLSVersions = versions
+	}
+	for i, step := range r.scenario {
+		summary.Scenario = append(summary.Scenario, StepSummary{
+			Step:   i + 1,
+			Name:   step.name,
+			Passed: step.passed.Load(),
+			Failed: step.failed.Load(),
+		})
+	}
+	if len(r.targets) > 1 {
+		for _, t := range r.targets {
+			summary.Targets = append(summary.Targets, TargetSummary{
+				URL:        t.url,
+				Weight:     t.weight,
+				Successful: atomic.LoadInt64(&t.succeeded),
+				Failed:     atomic.LoadInt64(&t.failed),
+			})
+		}
+	}
+	summary.Precheck = r.prechecks
+	return summary
+}
+
+// logSummary logs the final summary: the warmup phase, if any, then the
+// measured results, then one record per target.
+func (r *runner) logSummary(summary Summary, elapsed time.Duration) {
+	if w := summary.Warmup; w != nil {
+		r.log.Info("Warmup phase (not measured)",
+			"duration", time.Duration(w.DurationMs*float64(time.Millisecond)).Round(time.Millisecond),
+			"attempts", w.Attempts,
+			"succeeded", w.Successful,
+			"failed", w.Failed,
+			"reconnects", w.Reconnects,
+			"bytesRead", w.BytesRead,
+		)
+	}
+
+	attrs := []any{
+		"duration", elapsed.Round(time.Millisecond),
+		"seed", summary.Seed,
+		"attempts", summary.Attempts,
+		"successful", summary.Successful,
+		"failed", summary.Failed,
+		"successRatio", summary.SuccessRatio,
+	}
+	if summary.FailureCategories != nil {
+		attrs = append(attrs, "failuresByCause", countsValue(summary.FailureCategories))
+	}
+	attrs = append(attrs,
+		"reconnects", summary.Reconnects,
+		"activeAtEnd", summary.ActiveAtEnd,
+		"peakActive", summary.PeakActive,
+		"cleanCloses", summary.CleanCloses,
+		"abruptCloses", summary.AbruptCloses,
+		"bytesRead", summary.BytesRead,
+	)
+	if r.cfg.ConnectOnly {
+		attrs = append(attrs, slog.Float64("handshakesPerSec", roundRate(summary.HandshakesPerSec)))
+	}
+	if summary.RampUpMs > 0 {
+		attrs = append(attrs, "rampUp", time.Duration(summary.RampUpMs*float64(time.Millisecond)).Round(time.Millisecond))
+	}
+	if summary.AttemptsToTarget > 0 {
+		attrs = append(attrs, "attemptsToTarget", summary.AttemptsToTarget)
+	}
+	if r.cfg.ConnectionLifetime > 0 {
+		attrs = append(attrs, "connectionsOpened", summary.ConnectionsOpened, "closedByLifetime", summary.Churned)
+	}
+	if r.cfg.pingsEnabled() {
+		attrs = append(attrs, "pingsSent", summary.PingsSent, "pongsReceived", summary.PongsReceived)
+	}
+	if summary.PingsReceived > 0 || r.cfg.SlowPong > 0 {
+		attrs = append(attrs, "pingsReceived", summary.PingsReceived, "pongsSent", summary.PongsSent)
+		if summary.ServerPingInterval != nil {
+			attrs = append(attrs, "serverPingInterval", latencyDistribution(*summary.ServerPingInterval))
+		}
+	}
+	if r.cfg.HeartbeatInterval > 0 {
+		attrs = append(attrs, "heartbeatsSent", summary.HeartbeatsSent)
+	}
+	if r.cfg.Replay != nil {
+		attrs = append(attrs, "replaysCompleted", summary.ReplaysCompleted)
+	}
+	if r.cfg.sendsMessages() {
+		attrs = append(attrs, "messagesSent", summary.MessagesSent, "bytesWritten", summary.BytesWritten)
+	}
+	if summary.TargetRPS > 0 {
+		attrs = append(attrs, "targetRps", summary.TargetRPS, slog.Float64("achievedRps", roundRate(summary.AchievedRPS)))
+	}
+	if r.expect != nil {
+		attrs = append(attrs, "assertionFailures", summary.AssertionFailures)
+	}
+	if r.cfg.IdleTimeout > 0 {
+		attrs = append(attrs, "stalledConnections", summary.StalledConnections)
+	}
+	if r.cfg.BackpressureGap > 0 {
+		attrs = append(attrs, "backpressuredConnections", summary.BackpressuredConnections)
+	}
+	if r.cfg.Compression {
+		attrs = append(attrs, "compressed", summary.Compressed, "uncompressed", summary.Successful-summary.Compressed)
+	}
+	if summary.OversizedMessages > 0 {
+		attrs = append(attrs, "oversizedMessages", summary.OversizedMessages)
+	}
+	if summary.HandshakeLatency != nil {
+		attrs = append(attrs, "handshakeLatency", latencyDistribution(*summary.HandshakeLatency))
+	}
+	if summary.EchoLatency != nil {
+		attrs = append(attrs, "echoLatency", latencyDistribution(*summary.EchoLatency))
+	}
+	if summary.ResponseLatency != nil {
+		attrs = append(attrs, "responses", summary.Responses, "responseTimeouts", summary.ResponseTimeouts,
+			"responseTimeoutRate", summary.ResponseTimeoutRate,
+			"responseLatency", latencyDistribution(*summary.ResponseLatency))
+	}
+	if summary.FirstMessageLatency != nil {
+		attrs = append(attrs, "firstMessageLatency", latencyDistribution(*summary.FirstMessageLatency))
+	}
+	if summary.Interarrival != nil {
+		attrs = append(attrs, "interarrival", latencyDistribution(*summary.Interarrival))
+	}
+	if summary.EventsDropped > 0 {
+		attrs = append(attrs, "eventsDropped", summary.EventsDropped)
+	}
+	if summary.StatusCodes != nil {
+		attrs = append(attrs, "statusCodes", countsValue(summary.StatusCodes))
+	}
+	if summary.CloseCodes != nil {
+		attrs = append(attrs, "closeCodes", countsValue(summary.CloseCodes))
+	}
+	if summary.CloseReasons != nil {
+		reasons := make([]slog.Attr, 0, len(summary.CloseReasons))
+		for _, code := range sortedKeys(summary.CloseReasons) {
+			reasons = append(reasons, slog.String(code, summary.CloseReasons[code]))
+		}
+		attrs = append(attrs, "closeReasons", slog.GroupValue(reasons...))
+	}
+	if summary.Subprotocols != nil {
+		// An empty key can't be logged, so name the "none selected" count.
+		protocols := make(map[string]int64, len(summary.Subprotocols))
+		for name, n := range summary.Subprotocols {
+			if name == "" {
+				name = "(none)"
+			}
+			protocols[name] = n
+		}
+		attrs = append(attrs, "subprotocols", countsValue(protocols))
+	}
+	if summary.TLSVersions != nil {
+		attrs = append(attrs, "tlsVersions", countsValue(summary.TLSVersions))
+	}
+	r.log.Info("Test finished", attrs...)
+
+	for _, t := range summary.Targets {
+		r.log.Info("Target", "url", t.URL, "weight", t.Weight, "successful", t.Successful, "failed", t.Failed)
+	}
+
+	// Steps after a failing one never run, so the first step with the most
+	// failures is where conversations break down.
+	var worst *StepSummary
+	for i, s := range summary.Scenario {
+		r.log.Info("Scenario step", "step", s.Step, "name", s.Name, "passed", s.Passed, "failed", s.Failed)
+		if s.Failed > 0 && (worst == nil || s.Failed > worst.Failed) {
+			worst = &summary.Scenario[i]
+		}
+	}
+	if worst != nil {
+		r.log.Warn("Scenario step failing most often", "step", worst.Step, "name", worst.Name, "failed", worst.Failed)
+	}
+}
+
+func (s LatencyStats) MarshalJSON() ([]byte, error) {
+	type latencyRangeCount struct {
+		Range string `json:"range"`
+		Count uint64 `json:"count"`
+	}
+	var ranges []latencyRangeCount
+	if s.Count > 0 {
+		for i, c := range s.Ranges {
+			ranges = append(ranges, latencyRangeCount{latencyRangeNames[i], c})
+		}
+	}
+	return json.Marshal(struct {
+		Count    uint64              `json:"count"`
+		MinMs    float64             `json:"minMs"`
+		AvgMs    float64             `json:"avgMs"`
+		MaxMs    float64             `json:"maxMs"`
+		StdDevMs float64             `json:"stddevMs"`
+		P50Ms    float64             `json:"p50Ms"`
+		P95Ms    float64             `json:"p95Ms"`
+		P99Ms    float64             `json:"p99Ms"`
+		Ranges   []latencyRangeCount `json:"ranges,omitempty"`
+	}{
+		Count:    s.Count,
+		MinMs:    durationMs(s.Min),
+		AvgMs:    durationMs(s.Avg),
+		MaxMs:    durationMs(s.Max),
+		StdDevMs: durationMs(s.StdDev),
+		P50Ms:    durationMs(s.P50),
+		P95Ms:    durationMs(s.P95),
+		P99Ms:    durationMs(s.P99),
+		Ranges:   ranges,
+	})
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,228 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// unixScheme introduces a target reached over a Unix domain socket, as in
+// "ws+unix:///run/app.sock:/ws": the socket path, then the request path.
+const unixScheme = "ws+unix://"
+
+// weightSuffix introduces an optional weight on a URL entry, as in
+// "ws://host:8080/ws:weight=3".
+const weightSuffix = ":weight="
+
+// target is one WebSocket endpoint under test along with its own counters.
+type target struct {
+	url    string
+	weight int
+	// dialURL is what is dialed: url itself, or for a Unix socket target a
+	// ws:// URL with its request path, dialed through dialer.
+	dialURL string
+	dialer  *websocket.Dialer
+	socket  string
+	// header is the handshake header, which carries the target's own
+	// Origin unless one is configured.
+	header http.Header
+
+	succeeded int64
+	failed    int64
+}
+
+// parseTarget parses a single URL entry with an optional weight suffix.
+func parseTarget(entry string) (*target, error) {
+	raw, weight := entry, 1
+	if i := strings.LastIndex(entry, weightSuffix); i >= 0 {
+		w, err := strconv.Atoi(entry[i+len(weightSuffix):])
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid weight in %q: must be a positive integer", entry)
+		}
+		raw, weight = entry[:i], w
+	}
+
+	if strings.HasPrefix(raw, unixScheme) {
+		return parseUnixTarget(raw, weight)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL: %s. Error: %v", raw, err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("invalid WebSocket URL: %s. Scheme must be ws or wss", raw)
+	}
+	return &target{url: raw, weight: weight, dialURL: raw}, nil
+}
+
+// parseQuery turns "key=value" entries into query parameters, keeping
+// their order.
+func parseQuery(entries []string) (url.Values, []string, error) {
+	q := url.Values{}
+	var keys []string
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key == "" {
+			return nil, nil, fmt.Errorf("invalid query parameter (--query) %q: expected key=value", entry)
+		}
+		if _, seen := q[key]; !seen {
+			keys = append(keys, key)
+		}
+		q.Add(key, value)
+	}
+	return q, keys, nil
+}
+
+// addQuery appends the parameters to the query string t dials, after any the
+// URL already has, which are left as they were.
+func (t *target) addQuery(q url.Values, keys []string) error {
+	u, err := url.Parse(t.dialURL)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString(u.RawQuery)
+	for _, key := range keys {
+		for _, value := range q[key] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(key))
+			b.WriteByte('=')
+			b.WriteString(url.QueryEscape(value))
+		}
+	}
+	u.RawQuery = b.String()
+
+	dialURL := u.String()
+	if _, err := url.Parse(dialURL); err != nil {
+		return fmt.Errorf("invalid WebSocket URL with --query parameters: %v", err)
+	}
+	t.dialURL = dialURL
+	return nil
+}
+
+// parseUnixTarget parses a ws+unix:// URL. The socket must already exist so
+// that a wrong path is reported before the run starts.
+func parseUnixTarget(raw string, weight int) (*target, error) {
+	socket, path, _ := strings.Cut(strings.TrimPrefix(raw, unixScheme), ":")
+	if path == "" {
+		path = "/"
+	}
+	if socket == "" || !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid WebSocket URL: %s. Must be %s<socket path>:<request path>", raw, unixScheme)
+	}
+	u, err := url.Parse("ws://localhost" + path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL: %s. Error: %v", raw, err)
+	}
+	fi, err := os.Stat(socket)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL: %s. %v", raw, err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return nil, fmt.Errorf("invalid WebSocket URL: %s. %s is not a Unix socket", raw, socket)
+	}
+	return &target{url: raw, weight: weight, dialURL: u.String(), socket: socket}, nil
+}
+
+// LoadURLFile reads target URLs from a file, one entry per line in the form
+// Config.URLs takes. Blank lines and lines starting with # are skipped. Every
+// entry is validated here so a bad one is reported with its line number.
+func LoadURLFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := parseTarget(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		urls = append(urls, line)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s lists no URLs", path)
+	}
+	return urls, nil
+}
+
+// unixDialer derives a dialer from base that connects every handshake to
+// socket, giving up after connectTimeout if it is positive. Proxies and
+// source addresses don't apply to it.
+func unixDialer(base *websocket.Dialer, socket string, connectTimeout time.Duration) *websocket.Dialer {
+	d := *base
+	d.Proxy = nil
+	connect := connectFunc(nil, connectTimeout)
+	d.NetDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return connect(ctx, "unix", socket)
+	}
+	return &d
+}
+
+// URL selection modes for Config.URLSelect.
+const (
+	selectRoundRobin = "round-robin"
+	selectRandom     = "random"
+)
+
+// targetPool hands out targets in proportion to their weights. By default it
+// uses smooth weighted round-robin, so heavier targets are interleaved with
+// lighter ones instead of being picked in bursts; with rng set each pick is
+// an independent weighted draw instead.
+type targetPool struct {
+	mu      sync.Mutex
+	targets []*target
+	current []int
+	total   int
+	rng     *rand.Rand
+}
+
+func newTargetPool(targets []*target, rng *rand.Rand) *targetPool {
+	p := &targetPool{targets: targets, current: make([]int, len(targets)), rng: rng}
+	for _, t := range targets {
+		p.total += t.weight
+	}
+	return p
+}
+
+func (p *targetPool) next() *target {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rng != nil {
+		n := p.rng.IntN(p.total)
+		for _, t := range p.targets {
+			if n < t.weight {
+				return t
+			}
+			n -= t.weight
+		}
+	}
+
+	best := 0
+	for i, t := range p.targets {
+		p.current[i] += t.weight
+		if p.current[i] > p.current[best] {
+			best = i
+		}
+	}
+	p.current[best] -= p.total
+	return p.targets[best]
+}
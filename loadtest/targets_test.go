@@ -0,0 +1,113 @@
+package loadtest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketTarget(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "ws.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make(chan string, 16)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := testUpgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		select {
+		case paths <- req.URL.Path:
+		default:
+		}
+		for {
+			messageType, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, p)
+		}
+	})}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	tests := []struct {
+		name     string
+		poolSize int
+	}{
+		{name: "goroutine per connection"},
+		{name: "pool", poolSize: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.poolSize > 0 && !pollSupported {
+				t.Skip("the connection pool needs Linux")
+			}
+			cfg := testConfig(unixScheme + socket + ":/ws")
+			cfg.Duration = 100 * time.Millisecond
+			if tt.poolSize > 0 {
+				cfg.Soak, cfg.PoolSize = true, tt.poolSize
+			}
+			summary, err := Run(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.Successful != int64(cfg.Concurrency) || summary.CleanCloses != int64(cfg.Concurrency) {
+				t.Errorf("successful = %d, cleanCloses = %d, want %d", summary.Successful, summary.CleanCloses, cfg.Concurrency)
+			}
+			if path := <-paths; path != "/ws" {
+				t.Errorf("request path = %q, want /ws", path)
+			}
+		})
+	}
+}
+
+func TestParseUnixTarget(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "ws.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		raw     string
+		dialURL string
+		wantErr bool
+	}{
+		{raw: unixScheme + socket, dialURL: "ws://localhost/"},
+		{raw: unixScheme + socket + ":/chat?room=1", dialURL: "ws://localhost/chat?room=1"},
+		{raw: unixScheme + ":/ws", wantErr: true},
+		{raw: unixScheme + socket + ":ws", wantErr: true},
+		{raw: unixScheme + filepath.Join(dir, "missing.sock"), wantErr: true},
+		{raw: unixScheme + file, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseUnixTarget(tt.raw, 1)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseUnixTarget(%q) succeeded, want an error", tt.raw)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseUnixTarget(%q): %v", tt.raw, err)
+			continue
+		}
+		if got.socket != socket || got.dialURL != tt.dialURL {
+			t.Errorf("parseUnixTarget(%q) = socket %q, dial URL %q, want %q, %q", tt.raw, got.socket, got.dialURL, socket, tt.dialURL)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package loadtest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// tlsOptions are the TLS-related flags.
+type tlsOptions struct {
+	InsecureSkipVerify bool
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+	ServerName         string
+	MinVersion         string
+	MaxVersion         string
+	CipherSuites       []string
+}
+
+// tlsVersionNames maps the names --tls-min-version and --tls-max-version
+// accept to their versions.
+var tlsVersionNames = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// buildTLSConfig returns the TLS settings requested by opts, or nil if the
+// defaults (system roots, full verification, no client certificate) should
+// be used.
+func buildTLSConfig(opts tlsOptions) (*tls.Config, error) {
+	if (opts.ClientCert == "") != (opts.ClientKey == "") {
+		return nil, errors.New("-client-cert and -client-key must be given together")
+	}
+	if !opts.InsecureSkipVerify && opts.CACert == "" && opts.ClientCert == "" && opts.ServerName == "" &&
+		opts.MinVersion == "" && opts.MaxVersion == "" && len(opts.CipherSuites) == 0 {
+		return nil, nil
+	}
+
+	// The dialer only fills in ServerName from the URL host when it is
+	// empty, so this name is sent and verified against instead.
+	if strings.ContainsAny(opts.ServerName, ":/ ") {
+		return nil, fmt.Errorf("invalid server name (--sni) %q, expected a host name without scheme or port", opts.ServerName)
+	}
+	cfg := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify, ServerName: opts.ServerName}
+
+	var err error
+	if cfg.MinVersion, err = parseTLSVersion(opts.MinVersion, "--tls-min-version"); err != nil {
+		return nil, err
+	}
+	if cfg.MaxVersion, err = parseTLSVersion(opts.MaxVersion, "--tls-max-version"); err != nil {
+		return nil, err
+	}
+	if cfg.MinVersion != 0 && cfg.MaxVersion != 0 && cfg.MinVersion > cfg.MaxVersion {
+		return nil, errors.New("--tls-min-version is above --tls-max-version")
+	}
+	if len(opts.CipherSuites) > 0 {
+		if cfg.MinVersion == tls.VersionTLS13 {
+			return nil, errors.New("--cipher-suites only applies to TLS 1.2 and earlier, which --tls-min-version 1.3 rules out")
+		}
+		if cfg.CipherSuites, err = parseCipherSuites(opts.CipherSuites); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.CACert != "" {
+		pem, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", opts.CACert)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// parseTLSVersion parses a TLS version such as "1.2" given with flag; ""
+// leaves the crypto/tls default, 0.
+func parseTLSVersion(name, flag string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersionNames[strings.TrimPrefix(strings.ToLower(name), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version (%s) %q, expected 1.0, 1.1, 1.2 or 1.3", flag, name)
+	}
+	return v, nil
+}
+
+// parseCipherSuites resolves cipher suite names as crypto/tls spells them,
+// e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, insecure ones included since
+// testing what a server still accepts is the point. TLS 1.3 suites are
+// rejected: crypto/tls always offers all of them.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]*tls.CipherSuite)
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[s.Name] = s
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		s, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite (--cipher-suites) %q", name)
+		}
+		if len(s.SupportedVersions) == 1 && s.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("cipher suite (--cipher-suites) %s is a TLS 1.3 suite, which can't be restricted", s.Name)
+		}
+		ids = append(ids, s.ID)
+	}
+	return ids, nil
+}
+
+// negotiatedTLSVersion returns the TLS version conn negotiated, named as
+// --tls-min-version names it, or "" if it isn't a wss:// connection.
+func negotiatedTLSVersion(conn *websocket.Conn) string {
+	tc, ok := conn.NetConn().(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	version := tc.ConnectionState().Version
+	for name, v := range tlsVersionNames {
+		if v == version {
+			return name
+		}
+	}
+	return tls.VersionName(version)
+}
@@ -0,0 +1,993 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// workerState is what a worker carries from one connection to the next.
+// With Config.PoolSize the worker's goroutine exits while the pool holds its
+// connection, and the pool resumes the worker from here once it ends.
+type workerState struct {
+	id     uint64
+	t      *target
+	dialer *websocket.Dialer
+	wg     *sync.WaitGroup
+	log    *slog.Logger
+	rng    *rand.Rand
+	delays *backoff
+	// attempt counts the worker's dials and reconnectAttempts the failed
+	// ones since its last connection.
+	attempt           int
+	reconnectAttempts int
+	// reopening is set when the previous connection reached its lifetime,
+	// so the next dial is churn rather than a reconnect.
+	reopening bool
+	// opened counts the worker's successful connections, numbering each
+	// one in its log records.
+	opened int
+}
+
+func (r *runner) worker(ctx context.Context, id uint64, t *target, dialer *websocket.Dialer, wg *sync.WaitGroup) {
+	// Every random choice the worker makes comes from its own stream, so a
+	// run with the same seed makes the same choices.
+	rng := rand.New(rand.NewPCG(r.seed, id))
+	w := &workerState{
+		id:     id,
+		t:      t,
+		dialer: dialer,
+		wg:     wg,
+		// Every record the worker logs carries its id, which is also
+		// ConnID in payload templates, so one client can be followed
+		// across reconnects.
+		log:    r.log.With("worker", id),
+		rng:    rng,
+		delays: newBackoff(r.cfg.ReconnectDelay, r.cfg.ReconnectMaxDelay, rng),
+	}
+	r.runWorker(ctx, w)
+}
+
+// runWorker dials for w, and redials whenever its connection ends, until ctx
+// is done or the worker gives up. It returns early with w.wg still held once
+// the pool has taken a connection over.
+func (r *runner) runWorker(ctx context.Context, w *workerState) {
+	pooled := false
+	defer func() {
+		if !pooled {
+			w.wg.Done()
+		}
+	}()
+
+	log, t := w.log, w.t
+	defer func() {
+		if p := recover(); p != nil {
+			log.Error("Recovered from panic in worker", "panic", p)
+		}
+	}()
+
+	for ; ; w.attempt++ {
+		select {
+		case <-ctx.Done():
+			log.Debug("Worker skipping connection due to shutdown signal")
+			return
+		default:
+		}
+
+		if w.attempt > 0 && !w.reopening {
+			atomic.AddInt64(&r.stats.reconnects, 1)
+		}
+		w.reopening = false
+
+		atomic.AddInt64(&r.stats.attempts, 1)
+		dialStart := time.Now()
+		conn, resp, err := w.dialer.DialContext(ctx, t.dialURL, t.header)
+		if err != nil && stopping(ctx) {
+			// Shutdown cut the handshake short; that isn't the server's
+			// failure.
+			log.Debug("Worker abandoning connection due to shutdown signal", "err", err)
+			return
+		}
+		if err != nil {
+			r.recordFailure(t, classifyDialError(err, resp))
+			if resp != nil {
+				r.recordStatus(resp)
+			}
+			log.Debug("Connection failed", "url", t.url, "attempt", w.reconnectAttempts+1, "err", err)
+			if r.events != nil {
+				r.emitFailed(w.id, t, err, resp)
+			}
+			if w.opened == 0 && r.replacingFailures() {
+				// A replacement dial rather than a reconnect: it waits its
+				// turn in the Rate bucket like a new worker.
+				if r.connLimiter.Wait(ctx) != nil {
+					return
+				}
+				w.reopening = true
+				continue
+			}
+			if r.cfg.NoReconnect || (r.cfg.ReconnectAttempts > 0 && w.reconnectAttempts >= r.cfg.ReconnectAttempts) {
+				return
+			}
+			w.reconnectAttempts++
+			if !sleepCtx(ctx, w.delays.Next()) {
+				return
+			}
+			continue
+		}
+		handshake := time.Since(dialStart)
+		if r.measuring.Load() {
+			r.handshakes.Record(handshake)
+		}
+		w.reconnectAttempts = 0
+		w.delays.Reset()
+		atomic.AddInt64(&r.stats.successful, 1)
+		atomic.AddInt64(&t.succeeded, 1)
+		w.opened++
+		openedAt := time.Now()
+		local := conn.LocalAddr().String()
+		logger := log.With("connection", w.opened, "local", local)
+		if r.events != nil {
+			r.events.emit(event{Type: eventOpened, Worker: w.id, Connection: w.opened, URL: t.url, Local: local, HandshakeMs: durationMs(handshake)})
+		}
+		if len(r.cfg.Subprotocols) > 0 {
+			r.recordSubprotocol(logger, conn)
+		}
+		if version := negotiatedTLSVersion(conn); version != "" {
+			r.tlsVersions.Add(version)
+		}
+		if r.cfg.Compression {
+			r.recordCompression(logger, conn, resp)
+		}
+		if r.cfg.ConnectOnly {
+			r.countClose(r.closeHandshake(logger, conn))
+			conn.Close()
+			if r.events != nil {
+				r.events.emit(event{Type: eventClosed, Worker: w.id, Connection: w.opened, URL: t.url, Reason: "closed", LifetimeMs: durationMs(time.Since(openedAt))})
+			}
+			// The next dial waits its turn in the Rate bucket along with
+			// new workers still ramping up.
+			if r.cfg.NoReconnect || r.connLimiter.Wait(ctx) != nil {
+				return
+			}
+			w.reopening = true
+			continue
+		}
+		sc := &safeConn{Conn: conn, log: logger, num: w.opened}
+		if r.pool != nil {
+			pooled = true
+			r.holdPooled(ctx, w, sc, openedAt)
+			return
+		}
+		end := r.runConnection(ctx, w.id, sc, w.rng)
+		r.emitClosed(w, sc, end, openedAt)
+		if !r.nextConnection(ctx, w, end) {
+			return
+		}
+	}
+}
+
+// emitClosed writes the connection_closed event of one of w's connections.
+func (r *runner) emitClosed(w *workerState, sc *safeConn, end connEnd, openedAt time.Time) {
+	if r.events == nil {
+		return
+	}
+	r.events.emit(event{
+		Type: eventClosed, Worker: w.id, Connection: sc.num, URL: w.t.url, Reason: end.String(), CloseCode: sc.closeCode,
+		LifetimeMs: durationMs(time.Since(openedAt)), BytesRead: sc.bytesRead, BytesWritten: sc.bytesWritten.Load(),
+		MessagesSent: sc.messagesSent.Load(), MessagesReceived: sc.messagesReceived.Load(),
+	})
+}
+
+// nextConnection settles the end of one of w's connections and reports
+// whether the worker dials again, after its reconnect delay if it was
+// dropped.
+func (r *runner) nextConnection(ctx context.Context, w *workerState, end connEnd) bool {
+	switch end {
+	case connShutdown:
+		return false
+	case connExpired:
+		atomic.AddInt64(&r.stats.churned, 1)
+		w.reopening = true
+		return true
+	}
+
+	if r.cfg.NoReconnect {
+		r.recordFailure(w.t, failDropped)
+		w.log.Debug("Connection dropped and reconnection is disabled; worker exiting")
+		return false
+	}
+
+	// Jitter the first redial too, so connections dropped together by a
+	// server restart don't all come back at the same instant.
+	return sleepCtx(ctx, w.delays.Next())
+}
+
+// resumeWorker carries on with w once the pool's connection has ended, on a
+// goroutine of its own.
+func (r *runner) resumeWorker(ctx context.Context, w *workerState, end connEnd) {
+	if !r.nextConnection(ctx, w, end) {
+		w.wg.Done()
+		return
+	}
+	w.attempt++
+	r.runWorker(ctx, w)
+}
+
+// emitFailed writes a connection_failed event for a failed dial.
+func (r *runner) emitFailed(id uint64, t *target, err error, resp *http.Response) {
+	e := event{Type: eventFailed, Worker: id, URL: t.url, Category: classifyDialError(err, resp), Error: err.Error()}
+	if resp != nil {
+		e.Status = resp.StatusCode
+	}
+	r.events.emit(e)
+}
+
+// recordSubprotocol tallies the subprotocol the server selected and, in
+// verbose mode, logs when it isn't the one we preferred.
+func (r *runner) recordSubprotocol(log *slog.Logger, conn *websocket.Conn) {
+	selected := conn.Subprotocol()
+	r.subprotocols.Add(selected)
+
+	if selected != r.cfg.Subprotocols[0] {
+		log.Debug("Server selected a subprotocol other than the preferred one",
+			"selected", selected, "requested", r.cfg.Subprotocols)
+	}
+}
+
+// recordCompression counts whether the server accepted permessage-deflate
+// and, if so, applies the configured compression level.
+func (r *runner) recordCompression(log *slog.Logger, conn *websocket.Conn, resp *http.Response) {
+	negotiated := strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	log.Debug("Compression negotiation", "negotiated", negotiated)
+	if !negotiated {
+		return
+	}
+	atomic.AddInt64(&r.stats.compressed, 1)
+	conn.SetCompressionLevel(r.cfg.CompressionLevel)
+}
+
+// closeHandshake sends a normal close frame and waits up to
+// Config.CloseTimeout for the server's close frame in reply. It reports
+// whether the server completed the closing handshake. The caller must be the
+// connection's only reader and still has to close it.
+func (r *runner) closeHandshake(log *slog.Logger, conn *websocket.Conn) bool {
+	return r.sendClose(log, conn) && r.awaitClose(log, conn, nil)
+}
+
+// sendClose sends a normal close frame and gives the server
+// Config.CloseTimeout from now to answer it. WriteControl may be called
+// alongside the connection's other writes, so this doesn't need the reader.
+func (r *runner) sendClose(log *slog.Logger, conn *websocket.Conn) bool {
+	deadline := time.Now().Add(r.cfg.CloseTimeout)
+	if err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline); err != nil {
+		log.Debug("Close failed", "err", err)
+		return false
+	}
+	conn.SetReadDeadline(deadline)
+	return true
+}
+
+// awaitClose reads, discarding any messages still arriving, until the server's
+// close frame or an error, and reports whether it was the close frame. err is
+// the error a read has already returned, if any; gorilla/websocket returns it
+// again from every later read.
+func (r *runner) awaitClose(log *slog.Logger, conn *websocket.Conn, err error) bool {
+	for err == nil {
+		_, _, err = conn.NextReader()
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		log.Debug("Server didn't answer the close frame", "err", err, "closeTimeout", r.cfg.CloseTimeout)
+		return false
+	}
+	if closeErr.Code != websocket.CloseNormalClosure {
+		log.Debug("Server answered the close frame with another code", "err", err)
+	}
+	return true
+}
+
+// countClose tallies a client-initiated close as clean or abrupt.
+func (r *runner) countClose(clean bool) {
+	if clean {
+		atomic.AddInt64(&r.stats.cleanCloses, 1)
+	} else {
+		atomic.AddInt64(&r.stats.abruptCloses, 1)
+	}
+}
+
+// replacingFailures reports whether Config.RetryUntilTarget still has failed
+// dials retried in place of new workers: the target hasn't been reached yet
+// and its time isn't up.
+func (r *runner) replacingFailures() bool {
+	return r.cfg.RetryUntilTarget > 0 && r.fullAt.Load() == 0 && time.Now().Before(r.retryDeadline)
+}
+
+// jitterLifetime spreads connection lifetimes uniformly over d ± jitter·d,
+// so connections opened together don't all expire together.
+func jitterLifetime(rng *rand.Rand, d time.Duration, jitter float64) time.Duration {
+	spread := time.Duration(float64(d) * jitter)
+	if spread <= 0 {
+		return d
+	}
+	return d - spread + time.Duration(rng.Int64N(int64(2*spread)))
+}
+
+// maxHexDump is how much of a binary frame debug logging dumps.
+const maxHexDump = 256
+
+// hexDump hex-encodes the start of a binary frame for debug logging.
+func hexDump(p []byte) string {
+	if len(p) <= maxHexDump {
+		return hex.EncodeToString(p)
+	}
+	return hex.EncodeToString(p[:maxHexDump]) + "..."
+}
+
+// thinkTime returns Config.ThinkTime jittered by Config.ThinkJitter.
+func (r *runner) thinkTime(rng *rand.Rand) time.Duration {
+	return jitter(rng, r.cfg.ThinkTime, r.cfg.ThinkJitter)
+}
+
+// jitter returns d moved by a uniformly random amount within ±j, never below
+// zero.
+func jitter(rng *rand.Rand, d, j time.Duration) time.Duration {
+	if j > 0 {
+		d += time.Duration(rng.Int64N(int64(2*j+1))) - j
+	}
+	return max(d, 0)
+}
+
+// sleepCtx waits for d. It reports false if ctx was done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// safeConn serializes writes to a websocket.Conn, which supports at most one
+// concurrent writer. It also carries the connection's logger.
+type safeConn struct {
+	*websocket.Conn
+	mu  sync.Mutex
+	log *slog.Logger
+	// num is the worker's count of opened connections, this one included.
+	num int
+	// closeCode is the code of the close frame the server ended the
+	// connection with, if it sent one. Only the read loop sets it.
+	closeCode int
+	// bytesRead and messagesReceived total the data messages received, and
+	// are only updated by the read loop. messagesSent and bytesWritten
+	// total those sent by the send loop or scenario. They are the
+	// connection's own share of the run-wide counters, for its
+	// connection_closed event; messagesReceived is also compared with
+	// messagesSent for Config.BackpressureGap as messages go out.
+	bytesRead        int64
+	messagesReceived atomic.Int64
+	messagesSent     atomic.Int64
+	bytesWritten     atomic.Int64
+	// lastWrite is the UnixNano time the last data message was sent.
+	lastWrite atomic.Int64
+	// backpressured is set once the connection has been counted by
+	// Config.BackpressureGap, so it is counted only once.
+	backpressured atomic.Bool
+}
+
+func (c *safeConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.Conn.WriteMessage(messageType, data)
+	if err == nil && (messageType == websocket.TextMessage || messageType == websocket.BinaryMessage) {
+		c.lastWrite.Store(time.Now().UnixNano())
+	}
+	return err
+}
+
+// connEnd says why runConnection returned.
+type connEnd int
+
+const (
+	connShutdown connEnd = iota // ctx is done; the worker exits
+	connDropped                 // the connection failed; reconnect
+	connExpired                 // Config.ConnectionLifetime elapsed; reopen
+)
+
+func (e connEnd) String() string {
+	switch e {
+	case connShutdown:
+		return "shutdown"
+	case connExpired:
+		return "expired"
+	default:
+		return "dropped"
+	}
+}
+
+// countActive counts a newly established connection as active, noting when
+// Config.Concurrency is first reached and raising the peak.
+func (r *runner) countActive() {
+	active := atomic.AddInt64(&r.stats.active, 1)
+	if active == int64(r.cfg.Concurrency) && r.fullAt.CompareAndSwap(0, time.Now().UnixNano()) {
+		r.attemptsToTarget.Store(atomic.LoadInt64(&r.stats.attempts))
+	}
+	for peak := r.peakActive.Load(); active > peak && !r.peakActive.CompareAndSwap(peak, active); {
+		peak = r.peakActive.Load()
+	}
+}
+
+// runConnection reads from an established connection until it fails, its
+// lifetime is up or ctx is done. rng is the worker's random stream; it is
+// only used from this goroutine.
+func (r *runner) runConnection(ctx context.Context, id uint64, conn *safeConn, rng *rand.Rand) connEnd {
+	r.countActive()
+	defer atomic.AddInt64(&r.stats.active, -1)
+	defer conn.Close()
+
+	log := conn.log
+	debug := log.Enabled(ctx, slog.LevelDebug)
+
+	if r.cfg.MaxMessageSize > 0 {
+		conn.SetReadLimit(r.cfg.MaxMessageSize)
+	}
+
+	// connCtx is ctx, but also done once the connection's lifetime is up.
+	// Everything below watches it, so an expiring connection winds down
+	// exactly like one that is shutting down.
+	connCtx := ctx
+	if r.cfg.ConnectionLifetime > 0 {
+		var cancel context.CancelFunc
+		connCtx, cancel = context.WithTimeout(ctx, jitterLifetime(rng, r.cfg.ConnectionLifetime, r.cfg.LifetimeJitter))
+		defer cancel()
+	}
+	// Wake a blocked ReadMessage on expiry so the connection ends right
+	// away rather than when the read deadline expires. On shutdown the
+	// close frame goes out instead, and the blocked read returns the
+	// server's answer or times out after Config.CloseTimeout. woken is
+	// closed once either has happened; closeSent says whether the close
+	// frame was written.
+	woken := make(chan struct{})
+	var closeSent bool
+	stopWake := context.AfterFunc(connCtx, func() {
+		defer close(woken)
+		if ctx.Err() != nil {
+			log.Debug("Received shutdown, closing connection")
+			closeSent = r.sendClose(log, conn.Conn)
+			return
+		}
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stopWake()
+
+	// finish ends the connection once connCtx is done; err is the read
+	// error that noticed, if any.
+	finish := func(err error) connEnd {
+		if ctx.Err() != nil {
+			if stopWake() {
+				log.Debug("Received shutdown, closing connection")
+				r.countClose(r.closeHandshake(log, conn.Conn))
+			} else {
+				<-woken
+				r.countClose(closeSent && r.awaitClose(log, conn.Conn, err))
+			}
+			return connShutdown
+		}
+		log.Debug("Connection lifetime reached, reopening")
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		return connExpired
+	}
+
+	// lastRead is the UnixNano time of the last frame received, pongs
+	// included. The pinger uses it to only ping idle connections.
+	var lastRead atomic.Int64
+	markRead := func() {
+		lastRead.Store(time.Now().UnixNano())
+		r.extendReadDeadline(connCtx, conn)
+	}
+	markRead()
+	// lastMessage is when the previous data message arrived, or the
+	// handshake completed before the first one.
+	lastMessage := time.Now()
+	firstMessage := true
+
+	conn.SetPongHandler(func(string) error {
+		atomic.AddInt64(&r.stats.pongsReceived, 1)
+		log.Debug("Received pong")
+		markRead()
+		return nil
+	})
+	var lastPing time.Time
+	conn.SetPingHandler(func(data string) error {
+		now := time.Now()
+		atomic.AddInt64(&r.stats.pingsReceived, 1)
+		if !lastPing.IsZero() && r.measuring.Load() {
+			r.serverPingInterval.Record(now.Sub(lastPing))
+		}
+		lastPing = now
+		log.Debug("Received ping")
+		markRead()
+		if r.cfg.SlowPong > 0 {
+			time.AfterFunc(r.cfg.SlowPong, func() { r.sendPong(conn, data) })
+			return nil
+		}
+		return r.sendPong(conn, data)
+	})
+
+	connDone := make(chan struct{})
+	defer close(connDone)
+
+	var stalled atomic.Bool
+	if r.cfg.IdleTimeout > 0 {
+		go r.watchIdle(connCtx, conn, connDone, &lastRead, &stalled)
+	}
+
+	switch {
+	case r.soak != nil:
+		defer r.soak.add(id, conn)()
+	default:
+		if r.cfg.PingInterval > 0 {
+			go r.pingLoop(connCtx, conn, connDone, r.cfg.PingInterval, &lastRead)
+		}
+		if r.cfg.Keepalive > 0 {
+			go r.pingLoop(connCtx, conn, connDone, r.cfg.Keepalive, nil)
+		}
+	}
+
+	if r.cfg.HeartbeatInterval > 0 {
+		go r.heartbeatLoop(connCtx, id, conn, connDone)
+	}
+
+	var echoes *echoTracker
+	if r.cfg.Echo {
+		echoes = newEchoTracker()
+	}
+	var rpc *rpcTracker
+	if r.cfg.CorrelationField != "" {
+		rpc = newRPCTracker(r.cfg.CorrelationField)
+	}
+	var inbox chan []byte
+	if r.scenario != nil {
+		inbox = make(chan []byte, scenarioBacklog)
+		go r.runScenario(connCtx, id, conn, connDone, inbox)
+	} else if r.cfg.Replay != nil {
+		go r.runReplay(connCtx, conn, connDone)
+	} else if r.cfg.sendingEnabled() {
+		// The send loop runs alongside this goroutine, so it gets a
+		// stream of its own derived from the worker's.
+		go r.sendLoop(connCtx, id, conn, connDone, echoes, rpc, rand.New(rand.NewPCG(rng.Uint64(), rng.Uint64())))
+	}
+
+	for {
+		select {
+		case <-connCtx.Done():
+			return finish(nil)
+		default:
+		}
+
+		// A slow reader leaves frames queued in the socket while it pauses;
+		// the read timeout only counts from when it reads again.
+		if r.cfg.SlowRead > 0 {
+			if !sleepCtx(connCtx, jitter(rng, r.cfg.SlowRead, r.cfg.SlowReadJitter)) {
+				return finish(nil)
+			}
+			r.extendReadDeadline(connCtx, conn)
+		}
+
+		messageType, p, err := conn.ReadMessage()
+
+		if err != nil {
+			select {
+			case <-connCtx.Done():
+				return finish(err)
+			default:
+			}
+
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				r.recordClose(closeErr)
+				conn.closeCode = closeErr.Code
+			}
+
+			if stalled.Load() {
+				log.Debug("Nothing sent or received within the idle timeout, connection stalled", "idleTimeout", r.cfg.IdleTimeout)
+			} else if errors.Is(err, websocket.ErrReadLimit) {
+				atomic.AddInt64(&r.stats.oversized, 1)
+				log.Debug("Message exceeded the size limit, closing connection", "maxMessageSize", r.cfg.MaxMessageSize)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) ||
+				websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Debug("Connection closed", "err", err)
+			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				// A timed-out read leaves the connection unusable, so
+				// treat it as dead and reconnect.
+				log.Debug("Nothing received within the read timeout, reconnecting", "readTimeout", r.cfg.ReadTimeout)
+			} else {
+				log.Debug("Unhandled read error", "err", err)
+			}
+			return connDropped
+		}
+
+		markRead()
+		atomic.AddInt64(&r.stats.bytesRead, int64(len(p)))
+		conn.bytesRead += int64(len(p))
+		conn.messagesReceived.Add(1)
+
+		now := time.Now()
+		if r.measuring.Load() {
+			if firstMessage {
+				r.firstMessage.Record(now.Sub(lastMessage))
+			} else {
+				r.interarrival.Record(now.Sub(lastMessage))
+			}
+		}
+		lastMessage, firstMessage = now, false
+
+		if echoes != nil && messageType == r.cfg.messageType() {
+			if rtt, ok := echoes.match(p); ok && r.measuring.Load() {
+				r.echoLatency.Record(rtt)
+			}
+		}
+		if rpc != nil {
+			if rtt, ok := rpc.match(p); ok {
+				atomic.AddInt64(&r.stats.responses, 1)
+				if r.measuring.Load() {
+					r.responseLatency.Record(rtt)
+				}
+			}
+		}
+
+		if r.events != nil {
+			r.events.emit(event{Type: eventMessage, Worker: id, Connection: conn.num, Bytes: len(p)})
+		}
+
+		if r.expect != nil && messageType == websocket.TextMessage {
+			r.checkExpect(id, conn, p)
+		}
+
+		if inbox != nil {
+			select {
+			case inbox <- p:
+			default:
+			}
+		}
+
+		if debug {
+			switch messageType {
+			case websocket.TextMessage:
+				log.Debug("Received text message", "data", string(p))
+			case websocket.BinaryMessage:
+				log.Debug("Received binary message", "bytes", len(p), "hex", hexDump(p))
+			}
+		}
+	}
+}
+
+// recordClose tallies the code of a close frame the server sent, keeping the
+// reason text of the first one with each code.
+func (r *runner) recordClose(err *websocket.CloseError) {
+	code := strconv.Itoa(err.Code)
+	if r.closeCodes.Add(code) == 1 && err.Text != "" {
+		r.closeReasons.Store(code, err.Text)
+	}
+}
+
+// maxLoggedAssertions is how many mismatching messages debug logging shows.
+const maxLoggedAssertions = 3
+
+// checkExpect counts p as an assertion failure unless it matches
+// Config.Expect, logging the first few failures at debug level. Every
+// failure goes to the event stream.
+func (r *runner) checkExpect(id uint64, conn *safeConn, p []byte) {
+	if r.expect.Match(p) {
+		return
+	}
+	n := atomic.AddInt64(&r.stats.assertionFailures, 1)
+	if n > maxLoggedAssertions && r.events == nil {
+		return
+	}
+	text := string(p)
+	if len(p) > maxLoggedBody {
+		text = string(p[:maxLoggedBody]) + "..."
+	}
+	if n <= maxLoggedAssertions {
+		conn.log.Debug("Message did not match --expect", "expect", r.cfg.Expect, "data", text)
+	}
+	if r.events != nil {
+		r.events.emit(event{Type: eventAssertionFailed, Worker: id, Connection: conn.num, Bytes: len(p), Message: text})
+	}
+}
+
+// extendReadDeadline pushes the read deadline Config.ReadTimeout into the future.
+// All deadline resets go through here so they can't drift apart. Once the
+// test is shutting down the deadline is left alone, so a late pong can't
+// undo the wake-up that ends a blocked read.
+func (r *runner) extendReadDeadline(ctx context.Context, conn *safeConn) {
+	select {
+	case <-ctx.Done():
+		return
+	default:
+	}
+
+	if r.cfg.ReadTimeout <= 0 {
+		conn.SetReadDeadline(time.Time{})
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(r.cfg.ReadTimeout))
+}
+
+// watchIdle closes conn and sets stalled once nothing has been received
+// (lastRead) or sent (conn.lastWrite) for Config.IdleTimeout.
+func (r *runner) watchIdle(ctx context.Context, conn *safeConn, connDone <-chan struct{}, lastRead *atomic.Int64, stalled *atomic.Bool) {
+	timeout := r.cfg.IdleTimeout
+	ticker := time.NewTicker(max(timeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			last := max(lastRead.Load(), conn.lastWrite.Load())
+			if time.Since(time.Unix(0, last)) < timeout {
+				continue
+			}
+			atomic.AddInt64(&r.stats.stalled, 1)
+			stalled.Store(true)
+			conn.Close()
+			return
+		case <-connDone:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pingLoop sends a ping every interval. The resulting pong counts as a read
+// and extends the read deadline. When lastRead is non-nil, pings are only
+// sent once nothing has been received for interval (Config.PingInterval), so
+// quiet but healthy connections stay up while busy ones aren't pinged at
+// all; with a nil lastRead every tick pings (Config.Keepalive).
+func (r *runner) pingLoop(ctx context.Context, conn *safeConn, connDone <-chan struct{}, interval time.Duration, lastRead *atomic.Int64) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if lastRead != nil && time.Since(time.Unix(0, lastRead.Load())) < interval {
+				continue
+			}
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				conn.log.Debug("Ping failed", "err", err)
+				conn.Close()
+				return
+			}
+			atomic.AddInt64(&r.stats.pingsSent, 1)
+		case <-connDone:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pongWriteTimeout bounds writing a pong, as gorilla's default ping handler
+// does.
+const pongWriteTimeout = time.Second
+
+// sendPong answers a server ping with data. As in gorilla's default handler,
+// a connection already closing and a timed-out write aren't errors; the read
+// loop finds out about a dead connection by itself.
+func (r *runner) sendPong(conn *safeConn, data string) error {
+	// WriteControl may be called alongside the connection's other writes,
+	// so a delayed pong can be sent from a timer.
+	err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(pongWriteTimeout))
+	if err == nil {
+		atomic.AddInt64(&r.stats.pongsSent, 1)
+		return nil
+	}
+	if netErr, ok := err.(net.Error); err == websocket.ErrCloseSent || ok && netErr.Timeout() {
+		return nil
+	}
+	return err
+}
+
+// heartbeatLoop sends Config.HeartbeatMessage every Config.HeartbeatInterval
+// until the connection is done. A failed write closes the connection so the
+// read loop reconnects.
+func (r *runner) heartbeatLoop(ctx context.Context, id uint64, conn *safeConn, connDone <-chan struct{}) {
+	ticker := time.NewTicker(r.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	var buf bytes.Buffer
+	var seq uint64
+	for {
+		select {
+		case <-ticker.C:
+			msg := r.heartbeat
+			if r.heartbeatTmpl != nil {
+				var err error
+				msg, err = renderPayload(r.heartbeatTmpl, &buf, payloadData{ConnID: id, Seq: seq, Timestamp: time.Now().UnixNano()})
+				if err != nil {
+					conn.log.Debug("Rendering heartbeat failed", "err", err)
+					conn.Close()
+					return
+				}
+			}
+			seq++
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				conn.log.Debug("Heartbeat failed", "err", err)
+				conn.Close()
+				return
+			}
+			atomic.AddInt64(&r.stats.heartbeatsSent, 1)
+		case <-connDone:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendLoop writes the payload at Config.SendRate, with Config.ThinkTime
+// between messages, or whenever it gets a Config.TargetRPS permit, until the
+// connection is done. A template payload is
+// rendered for worker id first. When echoes is non-nil each payload carries a
+// sequence id so the read loop can measure its round trip; with rpc set each
+// carries a correlation id instead, and requests left unanswered are expired
+// as timeouts. A failed write closes the connection so the read loop
+// reconnects.
+func (r *runner) sendLoop(ctx context.Context, id uint64, conn *safeConn, connDone <-chan struct{}, echoes *echoTracker, rpc *rpcTracker, rng *rand.Rand) {
+	// tick fires when the next message is due: at a fixed rate, after a
+	// fresh think time following each send, or on a permit.
+	var tick <-chan time.Time
+	rearm := func() {}
+	switch {
+	case r.rpsPermits != nil:
+		tick = r.rpsPermits
+	case r.cfg.ThinkTime > 0:
+		timer := time.NewTimer(r.thinkTime(rng))
+		defer timer.Stop()
+		tick = timer.C
+		rearm = func() { timer.Reset(r.thinkTime(rng)) }
+	default:
+		sendRate := r.sendRate.Load()
+		ticker := time.NewTicker(time.Second / time.Duration(sendRate))
+		defer ticker.Stop()
+		tick = ticker.C
+		// A reload may change the rate; it takes effect from the next
+		// message.
+		rearm = func() {
+			if n := r.sendRate.Load(); n != sendRate {
+				sendRate = n
+				ticker.Reset(time.Second / time.Duration(n))
+			}
+		}
+	}
+
+	var sweep <-chan time.Time
+	if rpc != nil {
+		ticker := time.NewTicker(max(r.cfg.ResponseTimeout/4, 10*time.Millisecond))
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
+
+	var buf bytes.Buffer
+	var seq uint64
+
+	for {
+		select {
+		case <-sweep:
+			if n := rpc.expire(r.cfg.ResponseTimeout); n > 0 {
+				atomic.AddInt64(&r.stats.responseTimeouts, int64(n))
+				conn.log.Debug("Requests timed out waiting for a response", "count", n, "responseTimeout", r.cfg.ResponseTimeout)
+			}
+		case <-tick:
+			if r.sendLimiter != nil && !r.waitSendToken(ctx, connDone) {
+				return
+			}
+			payload := r.payload
+			if r.payloadTmpl != nil {
+				var err error
+				payload, err = renderPayload(r.payloadTmpl, &buf, payloadData{ConnID: id, Seq: seq, Timestamp: time.Now().UnixNano()})
+				if err != nil {
+					conn.log.Debug("Rendering payload failed", "err", err)
+					conn.Close()
+					return
+				}
+			}
+			seq++
+			if echoes != nil {
+				payload = echoes.next(payload)
+			}
+			if rpc != nil {
+				if !isJSONObject(payload) {
+					conn.log.Debug("Rendered payload is not a JSON object, can't correlate it")
+					conn.Close()
+					return
+				}
+				payload = rpc.next(payload)
+			}
+			if err := conn.WriteMessage(r.cfg.messageType(), payload); err != nil {
+				conn.log.Debug("Send failed", "err", err)
+				conn.Close()
+				return
+			}
+			r.countSent(conn, len(payload))
+			rearm()
+		case <-connDone:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// countSent counts a data message of n bytes sent on conn, run-wide and for
+// the connection itself, and flags conn as backpressured the first time its
+// sends get more than Config.BackpressureGap ahead of its receives.
+func (r *runner) countSent(conn *safeConn, n int) {
+	atomic.AddInt64(&r.stats.messagesSent, 1)
+	atomic.AddInt64(&r.stats.bytesWritten, int64(n))
+	sent := conn.messagesSent.Add(1)
+	conn.bytesWritten.Add(int64(n))
+
+	gap := r.cfg.BackpressureGap
+	if gap <= 0 || conn.backpressured.Load() {
+		return
+	}
+	if received := conn.messagesReceived.Load(); sent-received > gap && conn.backpressured.CompareAndSwap(false, true) {
+		atomic.AddInt64(&r.stats.backpressured, 1)
+		conn.log.Debug("Server falling behind, connection backpressured", "sent", sent, "received", received, "ratio", math.Round(float64(received)/float64(sent)*100)/100)
+	}
+}
+
+// waitSendToken blocks until the shared Config.TotalSendRate bucket allows
+// another message. Tokens go out in the order they were asked for, so every
+// connection gets its turn however many are waiting. It returns false if
+// the connection ended first.
+func (r *runner) waitSendToken(ctx context.Context, connDone <-chan struct{}) bool {
+	res := r.sendLimiter.Reserve()
+	delay := res.Delay()
+	if delay == 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-connDone:
+	case <-ctx.Done():
+	}
+	// Hand the token back so it isn't lost to the other connections.
+	res.Cancel()
+	return false
+}
+
+// stopping reports whether ctx is done or its deadline has passed. A dial
+// cut short by the deadline can fail a moment before ctx reports it.
+func stopping(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
@@ -0,0 +1,90 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSubprotocolNegotiated(t *testing.T) {
+	upgrader := testUpgrader
+	upgrader.Subprotocols = []string{"graphql-ws", "mqtt"}
+	url := newUpgraderServer(t, &upgrader, func(conn *websocket.Conn, _ *http.Request) {
+		conn.ReadMessage()
+	})
+
+	tests := []struct {
+		name      string
+		requested []string
+		want      string
+	}{
+		// The server picks by its own order of preference.
+		{name: "server's choice", requested: []string{"mqtt", "graphql-ws"}, want: "graphql-ws"},
+		{name: "only one shared", requested: []string{"stomp", "mqtt"}, want: "mqtt"},
+		{name: "none shared", requested: []string{"stomp"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(url)
+			cfg.Subprotocols = tt.requested
+			cfg.Duration = 100 * time.Millisecond
+			summary, err := Run(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := summary.Subprotocols[tt.want]; got != int64(cfg.Concurrency) || len(summary.Subprotocols) != 1 {
+				t.Errorf("subprotocols = %v, want %d of %q", summary.Subprotocols, cfg.Concurrency, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressionNegotiated(t *testing.T) {
+	tests := []struct {
+		name       string
+		server     bool
+		compressed int64
+	}{
+		{name: "server supports it", server: true, compressed: 2},
+		{name: "server declines"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upgrader := testUpgrader
+			upgrader.EnableCompression = tt.server
+			url := newUpgraderServer(t, &upgrader, func(conn *websocket.Conn, _ *http.Request) {
+				for {
+					messageType, p, err := conn.ReadMessage()
+					if err != nil {
+						return
+					}
+					conn.WriteMessage(messageType, p)
+				}
+			})
+			cfg := testConfig(url)
+			cfg.Compression = true
+			cfg.CompressionLevel = 9
+			cfg.Send = strings.Repeat("compressible ", 100)
+			cfg.SendRate = 20
+			cfg.Duration = 200 * time.Millisecond
+			summary, err := Run(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if summary.Compressed != tt.compressed {
+				t.Errorf("compressed = %d, want %d", summary.Compressed, tt.compressed)
+			}
+			// Compressed or not, the echoes come back whole, all but the
+			// last message of each connection, which may still be in flight.
+			size := int64(len(cfg.Send))
+			echoed := summary.BytesRead / size
+			if summary.BytesRead%size != 0 || echoed == 0 || echoed > summary.MessagesSent || echoed < summary.MessagesSent-int64(cfg.Concurrency) {
+				t.Errorf("bytesRead = %d for %d messages sent, want %d for each echoed", summary.BytesRead, summary.MessagesSent, size)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// levelVar is the log level in effect. A config reload may change it.
+var levelVar slog.LevelVar
+
+// parseLevel parses a -log-level value.
+func parseLevel(level string) (slog.Level, error) {
+	switch level {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level (--log-level): %q. Must be error, warn, info or debug", level)
+}
+
+// parseColor resolves a -color mode to whether output is colored. auto
+// colors only a terminal on stderr, where the logs and the dashboard go, and
+// honors the NO_COLOR convention and TERM=dumb.
+func parseColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb", nil
+	}
+	return false, fmt.Errorf("invalid color mode (--color): %q. Must be auto, always or never", mode)
+}
+
+// newLogger builds the logger selected by -log-format, logging at the level
+// in levelVar. With a sample above 1, only one in that many debug records
+// with the same message is kept. With color, text records have their level
+// colored; JSON records never are.
+func newLogger(w io.Writer, format string, sample int, color bool) (*slog.Logger, error) {
+	if sample < 1 {
+		return nil, fmt.Errorf("invalid verbose sample (--verbose-sample): %d. Must be positive", sample)
+	}
+
+	opts := &slog.HandlerOptions{Level: &levelVar}
+	var h slog.Handler
+	switch format {
+	case "text":
+		if color {
+			w = colorWriter{w}
+		}
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format (--log-format): %q. Must be text or json", format)
+	}
+	if sample > 1 {
+		h = &sampleHandler{next: h, n: int64(sample), seen: new(sync.Map)}
+	}
+	return slog.New(h), nil
+}
+
+// sampleHandler passes on one in n debug records per message, the message
+// standing for the kind of event. Other levels always pass. Dropped records
+// are never formatted, which is what keeps -v cheap at high concurrency.
+type sampleHandler struct {
+	next slog.Handler
+	n    int64
+	// seen maps each debug message to an *atomic.Int64 count, shared by
+	// every handler derived with WithAttrs or WithGroup.
+	seen *sync.Map
+}
+
+func (h *sampleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *sampleHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if rec.Level <= slog.LevelDebug {
+		v, ok := h.seen.Load(rec.Message)
+		if !ok {
+			v, _ = h.seen.LoadOrStore(rec.Message, new(atomic.Int64))
+		}
+		if (v.(*atomic.Int64).Add(1)-1)%h.n != 0 {
+			return nil
+		}
+	}
+	return h.next.Handle(ctx, rec)
+}
+
+func (h *sampleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sampleHandler{next: h.next.WithAttrs(attrs), n: h.n, seen: h.seen}
+}
+
+func (h *sampleHandler) WithGroup(name string) slog.Handler {
+	return &sampleHandler{next: h.next.WithGroup(name), n: h.n, seen: h.seen}
+}
+
+// levelColors are the ANSI colors of the levels in colored text records.
+var levelColors = []struct {
+	level string
+	code  string
+}{
+	{"DEBUG", "\x1b[2m"},
+	{"INFO", "\x1b[32m"},
+	{"WARN", "\x1b[33m"},
+	{"ERROR", "\x1b[31m"},
+}
+
+// colorWriter colors the level=... field of text log records. The text
+// handler writes each record with a single call and the level always
+// follows the time, so the first field of that name is the record's own.
+type colorWriter struct {
+	w io.Writer
+}
+
+func (c colorWriter) Write(p []byte) (int, error) {
+	i := bytes.Index(p, []byte("level="))
+	if i < 0 {
+		return c.w.Write(p)
+	}
+	start := i + len("level=")
+	end := start + bytes.IndexByte(p[start:], ' ')
+	if end < start {
+		return c.w.Write(p)
+	}
+	for _, lc := range levelColors {
+		// Levels between the named ones are written as e.g. WARN+2.
+		if bytes.HasPrefix(p[start:end], []byte(lc.level)) {
+			out := make([]byte, 0, len(p)+len(lc.code)+4)
+			out = append(out, p[:start]...)
+			out = append(out, lc.code...)
+			out = append(out, p[start:end]...)
+			out = append(out, "\x1b[0m"...)
+			out = append(out, p[end:]...)
+			if _, err := c.w.Write(out); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+	}
+	return c.w.Write(p)
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"go-socket-storm/loadtest"
+)
+
+// Exit statuses for a run that completed but failed one of its gates. 1 is
+// used for errors that stop a run from starting and 2 for an invalid
+// command line or config file.
+const (
+	exitErrorRate    = 3
+	exitAssertions   = 4
+	exitRampDeadline = 5
+)
+
+// outcomeStatus checks the summary against --max-error-rate,
+// --fail-on-assert and --ramp-deadline. Every gate that failed is logged,
+// and the exit status of the first is returned, or 0 if they all passed.
+func outcomeStatus(summary loadtest.Summary) int {
+	status := 0
+	fail := func(code int, msg string, args ...any) {
+		slog.Error(msg, args...)
+		if status == 0 {
+			status = code
+		}
+	}
+
+	if rate := summary.ErrorRate(); rate > *maxErrorRate {
+		fail(exitErrorRate, "Connection error rate exceeded the threshold (--max-error-rate)",
+			"errorRate", rate, "maxErrorRate", *maxErrorRate)
+	}
+	if *failOnAssert && summary.AssertionFailures > 0 {
+		fail(exitAssertions, "Received messages that didn't match --expect (--fail-on-assert)",
+			"assertionFailures", summary.AssertionFailures)
+	}
+	if *rampDeadline > 0 {
+		rampUp := time.Duration(summary.RampUpMs * float64(time.Millisecond))
+		if summary.RampUpMs == 0 || rampUp > *rampDeadline {
+			fail(exitRampDeadline, "Target concurrency wasn't reached in time (--ramp-deadline)",
+				"rampDeadline", *rampDeadline, "reached", summary.RampUpMs > 0)
+		}
+	}
+	return status
+}
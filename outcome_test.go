@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go-socket-storm/loadtest"
+)
+
+func TestOutcomeStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxErrorRate float64
+		failOnAssert bool
+		rampDeadline time.Duration
+		summary      loadtest.Summary
+		want         int
+	}{
+		{name: "all passed", maxErrorRate: 0.1, summary: loadtest.Summary{Attempts: 100, Successful: 95, Failed: 5}},
+		{name: "error rate at the threshold", maxErrorRate: 0.1, summary: loadtest.Summary{Attempts: 100, Successful: 90, Failed: 10}},
+		{name: "error rate over the threshold", maxErrorRate: 0.1, summary: loadtest.Summary{Attempts: 100, Successful: 89, Failed: 11}, want: exitErrorRate},
+		{name: "dropped connections count once", maxErrorRate: 0.18, summary: loadtest.Summary{Attempts: 10, Successful: 10, Failed: 2}, want: exitErrorRate},
+		{name: "no attempts", maxErrorRate: 0, summary: loadtest.Summary{}},
+		{name: "default allows every failure", maxErrorRate: 1, summary: loadtest.Summary{Attempts: 10, Failed: 10}},
+		{name: "assertions ignored", maxErrorRate: 1, summary: loadtest.Summary{AssertionFailures: 3}},
+		{name: "assertions failed", maxErrorRate: 1, failOnAssert: true, summary: loadtest.Summary{AssertionFailures: 3}, want: exitAssertions},
+		{name: "ramp in time", maxErrorRate: 1, rampDeadline: time.Second, summary: loadtest.Summary{RampUpMs: 900}},
+		{name: "ramp too slow", maxErrorRate: 1, rampDeadline: time.Second, summary: loadtest.Summary{RampUpMs: 1100}, want: exitRampDeadline},
+		{name: "target never reached", maxErrorRate: 1, rampDeadline: time.Second, want: exitRampDeadline},
+		{
+			name:         "first failed gate wins",
+			maxErrorRate: 0.1,
+			failOnAssert: true,
+			rampDeadline: time.Second,
+			summary:      loadtest.Summary{Attempts: 10, Successful: 1, Failed: 9, AssertionFailures: 1},
+			want:         exitErrorRate,
+		},
+	}
+	defer func(rate float64, assert bool, deadline time.Duration) {
+		*maxErrorRate, *failOnAssert, *rampDeadline = rate, assert, deadline
+	}(*maxErrorRate, *failOnAssert, *rampDeadline)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*maxErrorRate, *failOnAssert, *rampDeadline = tt.maxErrorRate, tt.failOnAssert, tt.rampDeadline
+			if got := outcomeStatus(tt.summary); got != tt.want {
+				t.Errorf("outcomeStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
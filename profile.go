@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiles starts a CPU profile if cpuPath is set. The returned stop func
+// ends it and, if memPath is set, writes a heap profile; it must be called
+// once the run is over, including when it was interrupted.
+func startProfiles(cpuPath, memPath string) (stop func(), err error) {
+	var cpuOut *os.File
+	if cpuPath != "" {
+		cpuOut, err = os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CPU profile (--cpuprofile): %w", err)
+		}
+		if err := pprof.StartCPUProfile(cpuOut); err != nil {
+			cpuOut.Close()
+			return nil, fmt.Errorf("failed to start CPU profile (--cpuprofile): %w", err)
+		}
+	}
+
+	return func() {
+		if cpuOut != nil {
+			pprof.StopCPUProfile()
+			if err := cpuOut.Close(); err != nil {
+				slog.Error("Failed to write CPU profile", "path", cpuPath, "err", err)
+			} else {
+				slog.Info("Wrote CPU profile", "path", cpuPath)
+			}
+		}
+		if memPath != "" {
+			if err := writeHeapProfile(memPath); err != nil {
+				slog.Error("Failed to write memory profile", "path", memPath, "err", err)
+			} else {
+				slog.Info("Wrote memory profile", "path", memPath)
+			}
+		}
+	}, nil
+}
+
+// writeHeapProfile writes a heap profile to path after a GC, so it shows
+// the memory still in use rather than garbage awaiting collection.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"slices"
+
+	"go-socket-storm/loadtest"
+)
+
+// liveFlags are the flags a reload applies to the running test.
+var liveFlags = []string{"stats-interval", "send-rate", "total-send-rate", "log-level", "v"}
+
+// handleReloads sets run.Reload and reloads the config file on every SIGHUP
+// until the returned func is called, which waits for a reload in progress so
+// the flags are settled once the run is over.
+func handleReloads(run *loadtest.Config) (stop func()) {
+	sigs := reloadSignals()
+	if sigs == nil {
+		return func() {}
+	}
+	reloads := make(chan loadtest.LiveConfig, 1)
+	run.Reload = reloads
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-sigs:
+			case <-done:
+				return
+			}
+			live, err := reloadConfig(*configPath)
+			if err != nil {
+				slog.Error("Failed to reload the config file, keeping the current settings", "err", err)
+				continue
+			}
+			select {
+			case reloads <- live:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// reloadConfig applies the config file and then the command line to the
+// flags again, as at startup, and returns the settings to pass on to the
+// run. The log level is applied here; other changed flags are logged as
+// ignored, since they can't change while the test runs.
+func reloadConfig(path string) (loadtest.LiveConfig, error) {
+	before := flagValues()
+	saved := cfg
+
+	if err := parseOverConfigFile(path, os.Args[1:]); err != nil {
+		cfg = saved
+		restoreFlags(before)
+		return loadtest.LiveConfig{}, err
+	}
+
+	var ignored []string
+	for name, v := range flagValues() {
+		if v != before[name] && !slices.Contains(liveFlags, name) {
+			ignored = append(ignored, name)
+		}
+	}
+	slices.Sort(ignored)
+
+	if *verbose {
+		*logLevel = "debug"
+	}
+	level, err := parseLevel(*logLevel)
+	if err != nil {
+		cfg = saved
+		restoreFlags(before)
+		return loadtest.LiveConfig{}, err
+	}
+	levelVar.Set(level)
+
+	slog.Info("Reloaded config file", "path", path, "logLevel", level)
+	if len(ignored) > 0 {
+		slog.Warn("Ignored changed settings that can't be applied while the test runs", "flags", ignored)
+	}
+	return loadtest.LiveConfig{
+		StatsInterval: cfg.StatsInterval,
+		SendRate:      cfg.SendRate,
+		TotalSendRate: cfg.TotalSendRate,
+	}, nil
+}
+
+// flagValues returns the current value of every flag by name.
+func flagValues() map[string]string {
+	values := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// restoreFlags puts back the flag values saved by flagValues. List flags
+// only add to what they hold, so they are restored with cfg instead.
+func restoreFlags(values map[string]string) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if !isListFlag(f) {
+			f.Value.Set(values[f.Name])
+		}
+	})
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// raiseFileLimit is a no-op where there is no RLIMIT_NOFILE.
+func raiseFileLimit(concurrency int, raise bool) {}
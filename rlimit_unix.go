@@ -0,0 +1,35 @@
+//go:build unix
+
+package main
+
+import (
+	"log/slog"
+	"syscall"
+)
+
+// raiseFileLimit raises the soft open-files limit to the hard limit, unless
+// raise is false, and warns when the resulting limit leaves too little room
+// for the requested number of connections. Each connection holds one file
+// descriptor.
+func raiseFileLimit(concurrency int, raise bool) {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		slog.Warn("Could not read the open-files limit", "err", err)
+		return
+	}
+	if raise && lim.Cur < lim.Max {
+		want := lim
+		want.Cur = lim.Max
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &want); err != nil {
+			slog.Warn("Could not raise the open-files limit", "from", lim.Cur, "to", lim.Max, "err", err)
+		} else {
+			lim = want
+		}
+	}
+
+	slog.Info("Open-files limit", "soft", lim.Cur, "hard", lim.Max, "connections", concurrency)
+	if uint64(concurrency)+fileHeadroom > uint64(lim.Cur) {
+		slog.Warn("-c is close to or above the open-files limit; expect \"too many open files\" failures. Raise it with `ulimit -n` or in /etc/security/limits.conf",
+			"connections", concurrency, "limit", lim.Cur)
+	}
+}
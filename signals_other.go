@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+// pauseSignals returns nil where there are no SIGUSR1 and SIGUSR2, so the
+// ramp-up can't be paused.
+func pauseSignals() <-chan bool { return nil }
+
+// statusSignals returns nil where there is no SIGQUIT.
+func statusSignals() <-chan struct{} { return nil }
+
+// reloadSignals returns nil where there is no SIGHUP.
+func reloadSignals() <-chan struct{} { return nil }
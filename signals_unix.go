@@ -0,0 +1,54 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// pauseSignals returns a channel that pauses the connection ramp-up on
+// SIGUSR1 and resumes it on SIGUSR2.
+func pauseSignals() <-chan bool {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	pause := make(chan bool)
+	go func() {
+		for sig := range sigs {
+			pause <- sig == syscall.SIGUSR1
+		}
+	}()
+	return pause
+}
+
+// statusSignals returns a channel that receives on every SIGQUIT, to log the
+// current stats on demand. Go would otherwise dump its goroutines and exit.
+func statusSignals() <-chan struct{} {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGQUIT)
+
+	status := make(chan struct{})
+	go func() {
+		for range sigs {
+			status <- struct{}{}
+		}
+	}()
+	return status
+}
+
+// reloadSignals returns a channel that receives on every SIGHUP, to reload
+// the config file.
+func reloadSignals() <-chan struct{} {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	reload := make(chan struct{})
+	go func() {
+		for range sigs {
+			reload <- struct{}{}
+		}
+	}()
+	return reload
+}